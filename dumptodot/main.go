@@ -4,72 +4,71 @@ import (
 	"flag"
 	"fmt"
 	"github.com/randall77/hprof/read"
+	"log"
+	"strconv"
+	"strings"
 )
 
+var (
+	mmap     = flag.Bool("mmap", false, "map the dump file into memory")
+	cache    = flag.Bool("cache", false, "keep the loaded dump in dumpfile.idx for faster restarts")
+	finroots = flag.Bool("finroots", false, "treat pending finalizers as roots, as the garbage collector does")
+	strLimit = flag.Int("strlen", 24, "show at most this many bytes of strings in labels")
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// stringLabels returns label lines showing the contents of the
+// string and byte slice fields in data.
+func stringLabels(d *read.Dump, data []byte, fields []read.Field) string {
+	s := ""
+	for _, f := range fields {
+		if v, ok := d.StringValue(data, f); ok {
+			s += fmt.Sprintf("\\n%s: %s", f.Name, dotEscaper.Replace(strconv.Quote(v)))
+		}
+	}
+	return s
+}
+
+// hasStrings reports whether fields include a string or slice.
+func hasStrings(fields []read.Field) bool {
+	for _, f := range fields {
+		if f.Kind == read.FieldKindString || f.Kind == read.FieldKindSlice {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
+	var opts []read.Option
+	if *mmap {
+		opts = append(opts, read.Mmap())
+	}
+	if *cache {
+		opts = append(opts, read.Cache(""))
+	}
+	if *finroots {
+		opts = append(opts, read.FinalizerRoots())
+	}
+	opts = append(opts, read.StringLimit(*strLimit))
 	var d *read.Dump
+	var err error
 	if len(args) == 2 {
-		d = read.Read(args[0], args[1])
+		d, err = read.Open(args[0], args[1], opts...)
 	} else {
-		d = read.Read(args[0], "")
-	}
-
-	// eliminate unreachable objects
-	// TODO: have reader do this?
-	reachable := make([]bool, d.NumObjects())
-	var q []read.ObjId
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
-	}
-	for _, x := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range x.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
+		d, err = read.Open(args[0], "", opts...)
 	}
-	for _, r := range d.Otherroots {
-		for _, e := range r.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
+	if err != nil {
+		log.Fatal(err)
 	}
-	for _, f := range d.QFinal {
-		for _, e := range f.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
 
-		}
-	}
-	for _, g := range d.Goroutines {
-		if g.Ctxt != read.ObjNil {
-			if !reachable[g.Ctxt] {
-				reachable[g.Ctxt] = true
-				q = append(q, g.Ctxt)
-			}
-		}
-	}
-	for len(q) > 0 {
-		x := q[0]
-		q = q[1:]
-		for _, e := range d.Edges(x) {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
+	// eliminate unreachable objects
+	reachable, err := d.Reachable()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	fmt.Printf("digraph {\n")
@@ -80,7 +79,11 @@ func main() {
 		if !reachable[x] {
 			fmt.Printf("  v%d [style=filled fillcolor=gray];\n", x)
 		}
-		fmt.Printf("  v%d [label=\"%s\\n%d\"];\n", x, d.Ft(x).Name, d.Size(x))
+		strs := ""
+		if ft := d.Ft(x); hasStrings(ft.Fields) {
+			strs = stringLabels(d, d.Contents(x), ft.Fields)
+		}
+		fmt.Printf("  v%d [label=\"%s\\n%d%s\"];\n", x, d.Ft(x).Name, d.Size(x), strs)
 		for _, e := range d.Edges(x) {
 			var taillabel, headlabel string
 			if e.FieldName != "" {
@@ -98,12 +101,21 @@ func main() {
 	// goroutines and stacks
 	for _, t := range d.Goroutines {
 		fmt.Printf("  \"goroutines\" [shape=diamond];\n")
-		fmt.Printf("  \"goroutines\" -> f%x_0;\n", t.Bos.Addr)
+		if sym := d.SymbolString(t.Gopc); sym != "" {
+			fmt.Printf("  \"goroutines\" -> f%x_0 [taillabel=\"%s\"];\n", t.Bos.Addr, sym)
+		} else {
+			fmt.Printf("  \"goroutines\" -> f%x_0;\n", t.Bos.Addr)
+		}
 	}
 
 	// stack frames
 	for _, f := range d.Frames {
-		fmt.Printf("  f%x_%d [label=\"%s\\n%d\" shape=rectangle];\n", f.Addr, f.Depth, f.Name, len(f.Data))
+		pos := ""
+		if f.File != "" {
+			pos = fmt.Sprintf("\\n%s:%d", f.File, f.Line)
+		}
+		strs := stringLabels(d, f.Data, f.Fields)
+		fmt.Printf("  f%x_%d [label=\"%s%s\\n%d%s\" shape=rectangle];\n", f.Addr, f.Depth, f.Name, pos, len(f.Data), strs)
 		if f.Parent != nil {
 			fmt.Printf("  f%x_%d -> f%x_%d;\n", f.Addr, f.Depth, f.Parent.Addr, f.Parent.Depth)
 		}
@@ -134,7 +146,10 @@ func main() {
 			}
 		}
 	}
-	for _, r := range d.Otherroots {
+	for _, r := range d.Roots() {
+		if r.Kind == read.RootStack || r.Kind == read.RootGlobal {
+			continue // drawn above
+		}
 		for _, e := range r.Edges {
 			var headlabel string
 			if e.ToOffset != 0 {
@@ -144,14 +159,11 @@ func main() {
 			fmt.Printf("  \"%s\" -> v%d%s;\n", r.Description, e.To, headlabel)
 		}
 	}
-	for _, f := range d.QFinal {
+	// pending finalizers don't keep anything alive, unless -finroots
+	for _, f := range d.Finalizers {
 		for _, e := range f.Edges {
-			var headlabel string
-			if e.ToOffset != 0 {
-				headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
-			}
-			fmt.Printf("  \"queued finalizers\" [shape=diamond];\n")
-			fmt.Printf("  \"queued finalizers\" -> v%d%s;\n", e.To, headlabel)
+			fmt.Printf("  \"finalizers\" [shape=diamond];\n")
+			fmt.Printf("  \"finalizers\" -> v%d [style=dashed taillabel=\"%s\"];\n", e.To, e.FieldName)
 		}
 	}
 
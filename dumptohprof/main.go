@@ -10,6 +10,7 @@ import (
 	"github.com/randall77/hprof/read"
 	"log"
 	"os"
+	"strconv"
 )
 
 // hprof constants
@@ -97,17 +98,29 @@ var stringCache map[string]uint64
 var threadSerialNumbers map[*read.GoRoutine]uint32
 var stackTraceSerialNumbers map[*read.GoRoutine]uint32
 
+var mmap = flag.Bool("mmap", false, "map the dump file into memory")
+var strLimit = flag.Int("strlen", 32, "show at most this many bytes of string globals in their class names")
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
+	var opts []read.Option
+	if *mmap {
+		opts = append(opts, read.Mmap())
+	}
+	opts = append(opts, read.StringLimit(*strLimit))
 	var outfile string
+	var err error
 	if len(args) == 2 {
-		d = read.Read(args[0], "")
+		d, err = read.Open(args[0], "", opts...)
 		outfile = args[1]
 	} else {
-		d = read.Read(args[0], args[1])
+		d, err = read.Open(args[0], args[1], opts...)
 		outfile = args[2]
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// some setup
 	usedIds = make(map[uint64]struct{}, 0)
@@ -228,8 +241,12 @@ func addThreads() {
 		body = append32(body, tid)
 		body = appendId(body, t.Addr)
 		body = append32(body, sid)
-		body = appendId(body, addString("threadname"))
-		body = appendId(body, addString("threadgroup"))
+		group := "threadgroup"
+		if sym := d.SymbolString(t.Gopc); sym != "" {
+			group = "created by " + sym
+		}
+		body = appendId(body, addString(fmt.Sprintf("goroutine %d", t.Goid)))
+		body = appendId(body, addString(group))
 		body = appendId(body, addString("threadparentgroup"))
 		addTag(HPROF_START_THREAD, body)
 
@@ -238,11 +255,15 @@ func addThreads() {
 		for f := t.Bos; f != nil; f = f.Parent {
 			body = nil
 			body = appendId(body, f.Addr)
+			file := f.File
+			if file == "" {
+				file = "dummysource.go"
+			}
 			body = appendId(body, addString(f.Name))
 			body = appendId(body, addString(""))
-			body = appendId(body, addString("dummysource.go"))
+			body = appendId(body, addString(file))
 			body = append32(body, go_class_ser)
-			body = append32(body, 0) // line # info
+			body = append32(body, uint32(f.Line)) // line # info, 0 if unknown
 			addTag(HPROF_FRAME, body)
 			n++
 		}
@@ -863,12 +884,21 @@ func addHeapDump() {
 	}
 	// data roots
 	for _, x := range []*read.Data{d.Data, d.Bss} {
+		// name string globals after their contents, before
+		// their pointers are adjusted below
+		names := make([]string, len(x.Fields))
+		for i, f := range x.Fields {
+			names[i] = f.Name
+			if s, ok := d.StringValue(x.Data, f); ok {
+				names[i] += " = " + strconv.Quote(s)
+			}
+		}
 		// adjust edges to point to object beginnings
 		for _, e := range x.Edges {
 			writePtr(x.Data[e.FromOffset:], d.Addr(e.To))
 		}
-		for _, f := range x.Fields {
-			addGlobal(f.Name, f.Kind, x.Data[f.Offset:])
+		for i, f := range x.Fields {
+			addGlobal(names[i], f.Kind, x.Data[f.Offset:])
 		}
 	}
 	for _, t := range d.Otherroots {
@@ -952,6 +982,6 @@ func writePtr(b []byte, v uint64) {
 		b[1] = byte(v >> 48)
 		b[0] = byte(v >> 56)
 	default:
-		log.Fatal("unsupported order=%v PtrSize=%d", d.Order, d.PtrSize)
+		log.Fatalf("unsupported order=%v PtrSize=%d", d.Order, d.PtrSize)
 	}
 }
@@ -0,0 +1,90 @@
+// Hdiff compares two heap dumps of the same program, to find out
+// what is growing.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/randall77/hprof/read"
+	"github.com/randall77/hprof/read/analysis"
+)
+
+var (
+	mmap     = flag.Bool("mmap", false, "map the dump files into memory")
+	cache    = flag.Bool("cache", false, "keep the loaded dumps in dumpfile.idx for faster restarts")
+	finroots = flag.Bool("finroots", false, "treat pending finalizers as roots, as the garbage collector does")
+	top      = flag.Int("n", 40, "number of types (and objects, with -new) to list; 0 means all")
+	newObjs  = flag.Bool("new", false, "also list the objects only in the newer dump; both dumps must come from one process")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdiff [flags] olddump newdump [executable]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 && len(args) != 3 {
+		usage()
+	}
+	var exec string
+	if len(args) == 3 {
+		exec = args[2]
+	}
+	var opts []read.Option
+	if *mmap {
+		opts = append(opts, read.Mmap())
+	}
+	if *cache {
+		opts = append(opts, read.Cache(""))
+	}
+	if *finroots {
+		opts = append(opts, read.FinalizerRoots())
+	}
+	old, err := read.Open(args[0], exec, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	new, err := read.Open(args[1], exec, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ot, err := analysis.Dominators(old)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nt, err := analysis.Dominators(new)
+	if err != nil {
+		log.Fatal(err)
+	}
+	deltas := analysis.DiffTypes(old, new, ot.TypeRetained(), nt.TypeRetained())
+	if *top > 0 && len(deltas) > *top {
+		deltas = deltas[:*top]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "count\tbytes\tretained\tnew count\tnew bytes\tnew retained\t type\n")
+	for _, t := range deltas {
+		fmt.Fprintf(w, "%+d\t%+d\t%+d\t%d\t%d\t%d\t %s\n", t.Count(), t.Bytes(), t.Retained(), t.New.Count, t.New.Bytes, t.New.Retained, t.Name)
+	}
+	w.Flush()
+
+	if *newObjs {
+		objs := analysis.NewObjects(old, new)
+		fmt.Printf("\n%d objects only in %s\n", len(objs), args[1])
+		if *top > 0 && len(objs) > *top {
+			objs = objs[:*top]
+		}
+		for _, x := range objs {
+			fmt.Printf("%x %d %s\n", new.Addr(x), new.Size(x), new.Ft(x).Name)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Hreport prints reports about the memory wasted in a heap dump,
+// and about its goroutines.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/randall77/hprof/read"
+	"github.com/randall77/hprof/read/analysis"
+)
+
+var (
+	mmap     = flag.Bool("mmap", false, "map the dump file into memory")
+	cache    = flag.Bool("cache", false, "keep the loaded dump in dumpfile.idx for faster restarts")
+	finroots = flag.Bool("finroots", false, "treat pending finalizers as roots, as the garbage collector does")
+	top      = flag.Int("n", 20, "number of entries to list in each report; 0 means all")
+	paths    = flag.Bool("paths", true, "show a path from a root to a sample of each entry")
+)
+
+// reports maps report names to the functions printing them.
+var reports = map[string]func(d *read.Dump){
+	"dups":       dupsReport,
+	"goroutines": goroutinesReport,
+	"overalloc":  overallocReport,
+	"slack":      slackReport,
+}
+
+func usage() {
+	var names []string
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr,
+		"usage: hreport [flags] report heapdump [executable]\n"+
+			"reports: %v\n", names)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 && len(args) != 3 {
+		usage()
+	}
+	report := reports[args[0]]
+	if report == nil {
+		usage()
+	}
+	var exec string
+	if len(args) == 3 {
+		exec = args[2]
+	}
+	var opts []read.Option
+	if *mmap {
+		opts = append(opts, read.Mmap())
+	}
+	if *cache {
+		opts = append(opts, read.Cache(""))
+	}
+	if *finroots {
+		opts = append(opts, read.FinalizerRoots())
+	}
+	d, err := read.Open(args[1], exec, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(d)
+}
+
+// dupsReport lists the groups of identical objects and strings,
+// with the groups of all-zero objects listed separately.
+func dupsReport(d *read.Dump) {
+	groups, err := analysis.Duplicates(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var dups, zeros []analysis.DupGroup
+	for _, g := range groups {
+		if g.Zero {
+			zeros = append(zeros, g)
+		} else {
+			dups = append(dups, g)
+		}
+	}
+	printDups(d, "Duplicate objects and strings", dups)
+	fmt.Println()
+	printDups(d, "All-zero objects", zeros)
+}
+
+func printDups(d *read.Dump, title string, groups []analysis.DupGroup) {
+	var wasted uint64
+	for i := range groups {
+		wasted += groups[i].Wasted()
+	}
+	fmt.Printf("%s: %d groups, %d bytes wasted\n", title, len(groups), wasted)
+	if *top > 0 && len(groups) > *top {
+		groups = groups[:*top]
+	}
+	var ps []analysis.Path
+	if *paths {
+		xs := make([]read.ObjId, len(groups))
+		for i := range groups {
+			xs[i] = groups[i].Objects[0]
+		}
+		var err error
+		if ps, err = analysis.ShortestPaths(d, xs); err != nil {
+			log.Printf("no paths: %v", err)
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "count\tsize\twasted\t type\n")
+	for i := range groups {
+		g := &groups[i]
+		name := g.Type
+		if g.Type == "string" {
+			name += " " + strconv.Quote(g.Value)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t %s\n", g.Count(), g.Size, g.Wasted(), name)
+		if ps != nil {
+			fmt.Fprintf(w, "\t\t\t   %s\n", rootPath(d, g.Objects[0], ps[i]))
+		}
+	}
+	w.Flush()
+}
+
+// maxPathEdges is the length beyond which rootPath leaves out
+// the middle of a path.
+const maxPathEdges = 6
+
+// rootPath returns a description of p, a path from a root to x.
+func rootPath(d *read.Dump, x read.ObjId, p analysis.Path) string {
+	if p.Root == nil {
+		return fmt.Sprintf("%x is unreachable", d.Addr(x))
+	}
+	s := p.Root.Description
+	edges := p.Edges
+	for i, e := range edges {
+		if len(edges) > maxPathEdges && i >= 2 && i < len(edges)-3 {
+			if i == 2 {
+				s += fmt.Sprintf(" -> ... %d more ...", len(edges)-5)
+			}
+			continue
+		}
+		if e.FieldName != "" && s != e.FieldName && !(len(edges) > maxPathEdges && i == len(edges)-3) {
+			s += "." + e.FieldName
+		}
+		s += fmt.Sprintf(" -> %s %x", d.Ft(e.To).Name, d.Addr(e.To))
+	}
+	return s
+}
+
+// overallocReport lists the fields holding the slices, maps and
+// channels with the most unused capacity.
+func overallocReport(d *read.Dump) {
+	fields, err := analysis.Overallocation(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var bytes, wasted, rounding uint64
+	for _, f := range fields {
+		bytes += f.Bytes
+		wasted += f.Wasted
+		rounding += f.Rounding
+	}
+	fmt.Printf("Slices, maps and channels: %d bytes, %d bytes wasted, %d bytes of rounding\n", bytes, wasted, rounding)
+	if *top > 0 && len(fields) > *top {
+		fields = fields[:*top]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "count\tlen\tcap\tbytes\twasted\trounding\tkind\t field\n")
+	for _, f := range fields {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%s\t %s %s\n", f.Count, f.Len, f.Cap, f.Bytes, f.Wasted, f.Rounding, f.Kind, f.Type, f.Field)
+	}
+	w.Flush()
+}
+
+// slackReport lists the types losing the most to size-class
+// rounding, and then the struct types that would save the most by
+// shrinking to the next smaller size class.
+func slackReport(d *read.Dump) {
+	types, err := analysis.SizeClassSlack(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var slack uint64
+	for _, t := range types {
+		slack += t.Slack
+	}
+	fmt.Printf("Size-class slack: %d types, %d bytes lost\n", len(types), slack)
+	list := types
+	if *top > 0 && len(list) > *top {
+		list = list[:*top]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "count\tsize\ttype size\tslack\tslack%%\t type\n")
+	for i := range list {
+		t := &list[i]
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.1f\t %s\n", t.Count, t.Type.Size, t.TypeSize, t.Slack, t.Percent(), t.Type.Name)
+	}
+	w.Flush()
+
+	var shrink []analysis.Slack
+	for _, t := range types {
+		if t.Saving > 0 {
+			shrink = append(shrink, t)
+		}
+	}
+	sort.SliceStable(shrink, func(i, j int) bool {
+		return shrink[i].Saving > shrink[j].Saving
+	})
+	if *top > 0 && len(shrink) > *top {
+		shrink = shrink[:*top]
+	}
+	fmt.Printf("\nStruct types to shrink to the next smaller size class\n")
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "count\ttype size\tshrink by\tsaving\t type\n")
+	for i := range shrink {
+		t := &shrink[i]
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t %s\n", t.Count, t.TypeSize, t.Shrink, t.Saving, t.Type.Name)
+	}
+	w.Flush()
+}
+
+// maxSampleGoroutines is the number of goroutines goroutinesReport
+// lists for each group.
+const maxSampleGoroutines = 5
+
+// goroutinesReport lists the goroutines grouped by stack signature,
+// in the style of the debug=1 goroutine profile of runtime/pprof.
+func goroutinesReport(d *read.Dump) {
+	groups := analysis.GroupGoroutines(d)
+	fmt.Printf("goroutines: %d in %d groups\n", len(d.Goroutines), len(groups))
+	if *top > 0 && len(groups) > *top {
+		groups = groups[:*top]
+	}
+	for i := range groups {
+		g := &groups[i]
+		fmt.Printf("\n%d @ %s", g.Count(), g.State)
+		if g.MaxWait != 0 {
+			fmt.Printf(", waiting since %d..%d", g.MinWait, g.MaxWait)
+		}
+		fmt.Println()
+		for _, f := range g.Funcs {
+			fmt.Printf("#\t%s\n", f)
+		}
+		if gr := g.Goroutines[0]; gr.CreatedBy != "" {
+			fmt.Printf("# created by %s at %s:%d\n", gr.CreatedBy, gr.CreatedFile, gr.CreatedLine)
+		} else if g.Gopc != 0 {
+			fmt.Printf("# created at pc %x\n", g.Gopc)
+		}
+		fmt.Printf("# goroutines")
+		for j, gr := range g.Goroutines {
+			if j == maxSampleGoroutines {
+				fmt.Printf(" and %d more", g.Count()-j)
+				break
+			}
+			fmt.Printf(" %x", gr.Addr)
+		}
+		fmt.Println()
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"github.com/randall77/hprof/read"
+	"github.com/randall77/hprof/read/analysis"
 	"html"
 	"log"
 	"net/http"
@@ -12,6 +14,8 @@ import (
 	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"text/template"
 )
 
@@ -22,6 +26,11 @@ const (
 
 var (
 	httpAddr = flag.String("http", defaultAddr, "HTTP service address")
+	mmap     = flag.Bool("mmap", false, "map the dump file into memory")
+	cache    = flag.Bool("cache", false, "keep analysis results in heapdump.idx for faster restarts")
+	finroots = flag.Bool("finroots", false, "treat pending finalizers as roots, as the garbage collector does")
+	baseDump = flag.String("base", "", "earlier heapdump of the same program to compare against, on the diff page")
+	strLimit = flag.Int("strlen", 64, "show at most this many bytes of strings")
 )
 
 // d is the loaded heap dump.
@@ -63,12 +72,21 @@ func nonheapPtr(b []byte) string {
 	p := readPtr(b)
 	if p == 0 {
 		return "nil"
+	} else if s := d.SymbolString(p); s != "" {
+		return s
 	} else {
-		// TODO: look up symbol in executable
 		return fmt.Sprintf("outsideheap_%x", p)
 	}
 }
 
+// returns a file:line string, or "" if the position is unknown
+func srcPos(file string, line int) string {
+	if file == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
 // display field
 type Field struct {
 	Name  string
@@ -90,6 +108,21 @@ func rawBytes(b []byte) string {
 	return v + " | " + html.EscapeString(s)
 }
 
+// stringValue returns html showing the contents of the string or
+// byte slice field f of b, which has length n, or "" if they are
+// not known.
+func stringValue(b []byte, f read.Field, n uint64) string {
+	s, ok := d.StringValue(b, f)
+	if !ok {
+		return ""
+	}
+	q := strconv.Quote(s)
+	if uint64(len(s)) < n {
+		q += "..."
+	}
+	return " " + html.EscapeString(q)
+}
+
 // getFields uses the data in b to fill in the values for the given field list.
 // edges is a list of known connecting out edges.
 func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
@@ -196,7 +229,8 @@ func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 			} else {
 				value = nonheapPtr(b[off:])
 			}
-			value = fmt.Sprintf("%s/%d", value, readPtr(b[off+d.PtrSize:]))
+			n := readPtr(b[off+d.PtrSize:])
+			value = fmt.Sprintf("%s/%d%s", value, n, stringValue(b, f, n))
 			off += 2 * d.PtrSize
 		case read.FieldKindSlice:
 			typ = "[]" + f.BaseType
@@ -206,7 +240,8 @@ func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 			} else {
 				value = nonheapPtr(b[off:])
 			}
-			value = fmt.Sprintf("%s/%d/%d", value, readPtr(b[off+d.PtrSize:]), readPtr(b[off+2*d.PtrSize:]))
+			n := readPtr(b[off+d.PtrSize:])
+			value = fmt.Sprintf("%s/%d/%d%s", value, n, readPtr(b[off+2*d.PtrSize:]), stringValue(b, f, n))
 			off += 3 * d.PtrSize
 		case read.FieldKindBytesElided:
 			typ = "raw bytes"
@@ -222,14 +257,117 @@ func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 }
 
 type objInfo struct {
+	Id        read.ObjId
 	Addr      uint64
 	Typ       string
 	Size      uint64
 	Fields    []Field
+	Map       *mapInfo
+	Chan      *chanInfo
 	Referrers []string
 	Dominates uint64
 }
 
+type mapInfo struct {
+	Type        string
+	Count       int
+	Buckets     int
+	BucketBytes uint64
+	LoadFactor  string
+	Entries     []mapEntry
+}
+
+type mapEntry struct {
+	Key, Value string
+}
+
+// getMap returns the contents of the map whose header is x,
+// or nil if x is not a map header.
+func getMap(x read.ObjId) (*mapInfo, error) {
+	m, err := d.Map(x)
+	if m == nil || err != nil {
+		return nil, err
+	}
+	i := &mapInfo{
+		Type:        m.Type,
+		Count:       m.Count,
+		Buckets:     m.Buckets,
+		BucketBytes: m.BucketBytes,
+		LoadFactor:  fmt.Sprintf("%.2f", m.LoadFactor()),
+	}
+	for _, e := range m.Entries {
+		if len(i.Entries) == maxFields-1 {
+			msg := fmt.Sprintf("<font color=Red>elided for display: %d entries</font>", len(m.Entries)-(maxFields-1))
+			i.Entries = append(i.Entries, mapEntry{msg, ""})
+			break
+		}
+		i.Entries = append(i.Entries, mapEntry{
+			fieldsValue(e.Key, m.KeyFields, e.KeyEdges),
+			fieldsValue(e.Value, m.ValueFields, e.ValueEdges),
+		})
+	}
+	return i, nil
+}
+
+type chanInfo struct {
+	Type     string
+	Len, Cap uint64
+	Closed   bool
+	Elems    []string
+	RecvWait []string
+	SendWait []string
+}
+
+// getChan returns the contents of the channel x, or nil if x is
+// not a channel.
+func getChan(x read.ObjId) (*chanInfo, error) {
+	c, err := d.Chan(x)
+	if c == nil || err != nil {
+		return nil, err
+	}
+	i := &chanInfo{
+		Type:   c.Type,
+		Len:    c.Len,
+		Cap:    c.Cap,
+		Closed: c.Closed,
+	}
+	for _, e := range c.Elems {
+		if len(i.Elems) == maxFields-1 {
+			msg := fmt.Sprintf("<font color=Red>elided for display: %d elements</font>", len(c.Elems)-(maxFields-1))
+			i.Elems = append(i.Elems, msg)
+			break
+		}
+		i.Elems = append(i.Elems, fieldsValue(e.Data, c.ElemFields, e.Edges))
+	}
+	waiters := func(gs []*read.GoRoutine) []string {
+		var r []string
+		for _, g := range gs {
+			r = append(r, fmt.Sprintf("<a href=go?id=%x>goroutine %x</a> %s", g.Addr, g.Addr, html.EscapeString(g.State())))
+		}
+		return r
+	}
+	i.RecvWait = waiters(c.RecvWait)
+	i.SendWait = waiters(c.SendWait)
+	return i, nil
+}
+
+// fieldsValue returns an html string representing a value made
+// of the given fields, on one line.
+func fieldsValue(b []byte, fields []read.Field, edges []read.Edge) string {
+	var s []string
+	for _, f := range getFields(b, fields, edges) {
+		if f.Typ == "" {
+			continue // padding
+		}
+		if f.Name == "" {
+			s = append(s, f.Value)
+		} else {
+			s = append(s, f.Name+":"+f.Value)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
 var objTemplate = template.Must(template.New("obj").Parse(`
 <html>
 <head>
@@ -263,7 +401,55 @@ border:1px solid grey;
 </tr>
 {{end}}
 </table>
+{{with .Map}}
+<h3>{{.Type}}: {{.Count}} entries, {{.Buckets}} buckets of {{.BucketBytes}} bytes, load factor {{.LoadFactor}}</h3>
+<table>
+<tr>
+<td>Key</td>
+<td>Value</td>
+</tr>
+{{range .Entries}}
+<tr>
+<td>{{.Key}}</td>
+<td>{{.Value}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{with .Chan}}
+<h3>{{.Type}}: {{if .Cap}}{{.Len}} of {{.Cap}} buffered{{else}}unbuffered{{end}}{{if .Closed}}, closed{{end}}</h3>
+{{if .Elems}}
+<table>
+<tr>
+<td>Next</td>
+<td>Element</td>
+</tr>
+{{range $i, $e := .Elems}}
+<tr>
+<td>{{$i}}</td>
+<td>{{$e}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{if .RecvWait}}
+<h3>Blocked receiving</h3>
+{{range .RecvWait}}
+{{.}}
+<br>
+{{end}}
+{{end}}
+{{if .SendWait}}
+<h3>Blocked sending</h3>
+{{range .SendWait}}
+{{.}}
+<br>
+{{end}}
+{{end}}
+{{end}}
 <h3>Referrers</h3>
+<a href=path?id={{.Id}}>paths from roots</a>
+<br>
 {{range .Referrers}}
 {{.}}
 <br>
@@ -294,14 +480,40 @@ func objHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	x := read.ObjId(id)
 
-	fld := getFields(d.Contents(x), d.Ft(x).Fields, d.Edges(x))
+	// Handlers run concurrently, so use the concurrency-safe accessors.
+	b, err := d.ContentsInto(x, nil)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	edges, err := d.EdgesInto(x, nil)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	fld := getFields(b, d.Ft(x).Fields, edges)
 	if len(fld) > maxFields {
 		msg := fmt.Sprintf("<font color=Red>elided for display: %d fields</font>", len(fld)-(maxFields-1))
 		fld = fld[:maxFields-1]
 		fld = append(fld, Field{msg, "", ""})
 	}
 
-	ref := getReferrers(x)
+	mi, err := getMap(x)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	ci, err := getChan(x)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ref, err := getReferrers(x)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
 	if len(ref) > maxFields {
 		msg := fmt.Sprintf("<font color=Red>elided for display: %d referrers</font>", len(ref)-(maxFields-1))
 		ref = ref[:maxFields-1]
@@ -309,10 +521,13 @@ func objHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	info := objInfo{
+		x,
 		d.Addr(x),
 		typeLink(d.Ft(x)),
 		d.Size(x),
 		fld,
+		mi,
+		ci,
 		ref,
 		domsize[x],
 	}
@@ -450,6 +665,7 @@ type mainInfo struct {
 	HeapSize   uint64
 	HeapUsed   uint64
 	NumObjects int
+	Diff       bool
 }
 
 var mainTemplate = template.Must(template.New("histo").Parse(`
@@ -472,18 +688,450 @@ Heap objects: {{.NumObjects}}
 <a href="globals">Globals</a>
 <a href="goroutines">Goroutines</a>
 <a href="others">Miscellaneous Roots</a>
+<a href="dups">Duplicates</a>
+<a href="overalloc">Over-allocation</a>
+<a href="slack">Size-class slack</a>
+{{if .Diff}}<a href="diff">Diff against base</a>{{end}}
 </tt>
 </body>
 </html>
 `))
 
 func mainHandler(w http.ResponseWriter, r *http.Request) {
-	i := mainInfo{d.HeapEnd - d.HeapStart, d.Memstats.Alloc, d.NumObjects()}
+	i := mainInfo{d.HeapEnd - d.HeapStart, d.Memstats.Alloc, d.NumObjects(), base != nil}
 	if err := mainTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
 }
 
+// base is the earlier dump given with -base, or nil.
+var base *read.Dump
+
+// growth by type since base
+var deltas []analysis.TypeDelta
+
+var diffTemplate = template.Must(template.New("diff").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Diff against base</title>
+</head>
+<body>
+<tt>
+<h2>Growth since {{.Base}}</h2>
+<a href="diff?new=1">Objects not in base</a>
+<table>
+<tr>
+<td>Type</td>
+<td align="right">Count</td>
+<td align="right">Bytes</td>
+<td align="right">Retained</td>
+<td align="right">Base count</td>
+<td align="right">Base bytes</td>
+<td align="right">Base retained</td>
+</tr>
+{{range .Deltas}}
+<tr>
+<td>{{.Name}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Bytes}}</td>
+<td align="right">{{.Retained}}</td>
+<td align="right">{{.Old.Count}}</td>
+<td align="right">{{.Old.Bytes}}</td>
+<td align="right">{{.Old.Retained}}</td>
+</tr>
+{{end}}
+</table>
+{{if .Objects}}
+<h3>Objects not in base</h3>
+{{range .Objects}}
+{{.}}
+<br>
+{{end}}
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+type diffEntry struct {
+	Name     string
+	Count    string
+	Bytes    string
+	Retained string
+	Old      analysis.TypeStats
+}
+
+type diffInfo struct {
+	Base    string
+	Deltas  []diffEntry
+	Objects []string
+}
+
+// diffHandler shows the growth of each type since the base dump.
+// With new=1 it also lists the objects that are not in the base
+// dump, which is meaningful only if both dumps are of one process.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	if base == nil {
+		http.Error(w, "no base dump; use -base", 404)
+		return
+	}
+	// link to the type's page in this dump, if it has one
+	types := map[string]*read.FullType{}
+	for _, ft := range d.FTList {
+		if _, ok := types[ft.Name]; !ok {
+			types[ft.Name] = ft
+		}
+	}
+	info := diffInfo{Base: *baseDump}
+	for _, t := range deltas {
+		name := html.EscapeString(t.Name)
+		if ft := types[t.Name]; ft != nil {
+			name = typeLink(ft)
+		}
+		info.Deltas = append(info.Deltas, diffEntry{
+			name,
+			fmt.Sprintf("%+d", t.Count()),
+			fmt.Sprintf("%+d", t.Bytes()),
+			fmt.Sprintf("%+d", t.Retained()),
+			t.Old,
+		})
+	}
+	if r.URL.Query().Get("new") == "1" {
+		objs := analysis.NewObjects(base, d)
+		for _, x := range objs {
+			if len(info.Objects) == maxFields-1 {
+				info.Objects = append(info.Objects, fmt.Sprintf("<font color=Red>elided for display: %d objects</font>", len(objs)-(maxFields-1)))
+				break
+			}
+			info.Objects = append(info.Objects, fmt.Sprintf("%s %s", objLink(x), typeLink(d.Ft(x))))
+		}
+	}
+	if err := diffTemplate.Execute(w, info); err != nil {
+		log.Print(err)
+	}
+}
+
+// groups of identical objects and strings, computed on first use
+var (
+	dupsOnce   sync.Once
+	dupsGroups []analysis.DupGroup
+	dupsErr    error
+)
+
+// maxDups is the number of groups of each kind the dups page lists.
+const maxDups = 200
+
+var dupsTemplate = template.Must(template.New("dups").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Duplicates</title>
+</head>
+<body>
+<tt>
+{{range .}}
+<h2>{{.Title}}: {{.Groups}} groups, {{.Wasted}} bytes wasted</h2>
+<table>
+<tr>
+<td>Type</td>
+<td align="right">Count</td>
+<td align="right">Size</td>
+<td align="right">Wasted</td>
+<td>Sample</td>
+</tr>
+{{range .Entries}}
+<tr>
+<td>{{.Type}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Size}}</td>
+<td align="right">{{.Wasted}}</td>
+<td>{{.Sample}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+type dupsSection struct {
+	Title   string
+	Groups  int
+	Wasted  uint64
+	Entries []dupsEntry
+}
+
+type dupsEntry struct {
+	Type   string
+	Count  int
+	Size   uint64
+	Wasted uint64
+	Sample string
+}
+
+// dupsHandler lists the groups of identical objects and strings,
+// and separately the groups of all-zero objects.
+func dupsHandler(w http.ResponseWriter, r *http.Request) {
+	dupsOnce.Do(func() {
+		dupsGroups, dupsErr = analysis.Duplicates(d)
+	})
+	if dupsErr != nil {
+		http.Error(w, dupsErr.Error(), 500)
+		return
+	}
+	sections := []dupsSection{{Title: "Duplicate objects and strings"}, {Title: "All-zero objects"}}
+	for i := range dupsGroups {
+		g := &dupsGroups[i]
+		s := &sections[0]
+		if g.Zero {
+			s = &sections[1]
+		}
+		s.Groups++
+		s.Wasted += g.Wasted()
+		if len(s.Entries) == maxDups {
+			continue
+		}
+		x := g.Objects[0]
+		typ := typeLink(d.Ft(x))
+		if g.Type == "string" {
+			typ = "string " + html.EscapeString(strconv.Quote(g.Value))
+		}
+		s.Entries = append(s.Entries, dupsEntry{
+			typ,
+			g.Count(),
+			g.Size,
+			g.Wasted(),
+			fmt.Sprintf("%s <a href=path?id=%d>paths from roots</a>", objLink(x), x),
+		})
+	}
+	if err := dupsTemplate.Execute(w, sections); err != nil {
+		log.Print(err)
+	}
+}
+
+// unused capacity by field, computed on first use
+var (
+	overallocOnce   sync.Once
+	overallocFields []analysis.Overalloc
+	overallocErr    error
+)
+
+var overallocTemplate = template.Must(template.New("overalloc").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Over-allocation</title>
+</head>
+<body>
+<tt>
+<h2>Slices, maps and channels: {{.Bytes}} bytes, {{.Wasted}} bytes wasted, {{.Rounding}} bytes of rounding</h2>
+<table>
+<tr>
+<td>Type</td>
+<td>Field</td>
+<td>Kind</td>
+<td align="right">Count</td>
+<td align="right">Len</td>
+<td align="right">Cap</td>
+<td align="right">Bytes</td>
+<td align="right">Wasted</td>
+<td align="right">Rounding</td>
+</tr>
+{{range .Fields}}
+<tr>
+<td>{{.Type}}</td>
+<td>{{.Field}}</td>
+<td>{{.Kind}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Len}}</td>
+<td align="right">{{.Cap}}</td>
+<td align="right">{{.Bytes}}</td>
+<td align="right">{{.Wasted}}</td>
+<td align="right">{{.Rounding}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+type overallocInfo struct {
+	Bytes, Wasted, Rounding uint64
+	Fields                  []analysis.Overalloc
+}
+
+// overallocHandler lists the fields holding the slices, maps and
+// channels with the most unused capacity.
+func overallocHandler(w http.ResponseWriter, r *http.Request) {
+	overallocOnce.Do(func() {
+		overallocFields, overallocErr = analysis.Overallocation(d)
+	})
+	if overallocErr != nil {
+		http.Error(w, overallocErr.Error(), 500)
+		return
+	}
+	var i overallocInfo
+	for _, f := range overallocFields {
+		i.Bytes += f.Bytes
+		i.Wasted += f.Wasted
+		i.Rounding += f.Rounding
+		if len(i.Fields) < maxFields-1 {
+			f.Type = html.EscapeString(f.Type)
+			f.Field = html.EscapeString(f.Field)
+			i.Fields = append(i.Fields, f)
+		}
+	}
+	if err := overallocTemplate.Execute(w, i); err != nil {
+		log.Print(err)
+	}
+}
+
+var slackTemplate = template.Must(template.New("slack").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Size-class slack</title>
+</head>
+<body>
+<tt>
+{{if .Err}}
+<h2>Size-class slack</h2>
+{{.Err}}
+{{else}}
+<h2>Size-class slack: {{len .Types}} types, {{.Slack}} bytes lost</h2>
+<table>
+<tr>
+<td>Type</td>
+<td align="right">Count</td>
+<td align="right">Size</td>
+<td align="right">Type size</td>
+<td align="right">Slack</td>
+<td align="right">Slack %</td>
+</tr>
+{{range .Types}}
+<tr>
+<td>{{.Name}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Size}}</td>
+<td align="right">{{.TypeSize}}</td>
+<td align="right">{{.Slack}}</td>
+<td align="right">{{.Percent}}</td>
+</tr>
+{{end}}
+</table>
+<h2>Struct types to shrink to the next smaller size class</h2>
+<table>
+<tr>
+<td>Type</td>
+<td align="right">Count</td>
+<td align="right">Type size</td>
+<td align="right">Shrink by</td>
+<td align="right">Saving</td>
+</tr>
+{{range .Shrink}}
+<tr>
+<td>{{.Name}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.TypeSize}}</td>
+<td align="right">{{.Shrink}}</td>
+<td align="right">{{.Saving}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+type slackEntry struct {
+	Name     string
+	Count    int
+	Size     uint64
+	TypeSize uint64
+	Slack    uint64
+	Percent  string
+	Shrink   uint64
+	Saving   uint64
+}
+
+type slackInfo struct {
+	Err    error // from analysis.SizeClassSlack
+	Slack  uint64
+	Types  []slackEntry
+	Shrink []slackEntry
+}
+
+// slackHandler lists the types losing the most to size-class
+// rounding, and the struct types that would save the most by
+// shrinking to the next smaller size class.
+func slackHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := analysis.SizeClassSlack(d)
+	i := slackInfo{Err: err}
+	for _, t := range types {
+		e := slackEntry{
+			typeLink(t.Type),
+			t.Count,
+			t.Type.Size,
+			t.TypeSize,
+			t.Slack,
+			fmt.Sprintf("%.1f", t.Percent()),
+			t.Shrink,
+			t.Saving,
+		}
+		i.Slack += t.Slack
+		i.Types = append(i.Types, e)
+		if t.Saving > 0 {
+			i.Shrink = append(i.Shrink, e)
+		}
+	}
+	sort.SliceStable(i.Shrink, func(a, b int) bool {
+		return i.Shrink[a].Saving > i.Shrink[b].Saving
+	})
+	if err := slackTemplate.Execute(w, i); err != nil {
+		log.Print(err)
+	}
+}
+
 var globalsTemplate = template.Must(template.New("globals").Parse(`
 <html>
 <head>
@@ -552,7 +1200,7 @@ border:1px solid grey;
 <table>
 <tr>
 <td>Name</td>
-<td>Type</td>
+<td>Kind</td>
 <td>Value</td>
 </tr>
 {{range .}}
@@ -570,9 +1218,12 @@ border:1px solid grey;
 
 func othersHandler(w http.ResponseWriter, r *http.Request) {
 	var f []Field
-	for _, x := range d.Otherroots {
+	for _, x := range d.Roots() {
+		if x.Kind == read.RootStack || x.Kind == read.RootGlobal {
+			continue // shown on the goroutine and globals pages
+		}
 		for _, e := range x.Edges {
-			f = append(f, Field{x.Description, "unknown", edgeLink(e)})
+			f = append(f, Field{x.Description, x.Kind.String(), edgeLink(e)})
 		}
 	}
 	if err := othersTemplate.Execute(w, f); err != nil {
@@ -580,9 +1231,115 @@ func othersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type goListInfo struct {
-	Name  string
-	State string
+var pathTemplate = template.Must(template.New("path").Parse(`
+<html>
+<head>
+<title>Paths to object {{printf "%x" .Addr}}</title>
+</head>
+<body>
+<tt>
+<h2>Paths from roots to {{.Obj}}</h2>
+{{range .Paths}}
+<p>
+{{range .}}
+{{.}}
+<br>
+{{end}}
+</p>
+{{else}}
+not reachable
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+type pathInfo struct {
+	Addr  uint64
+	Obj   string
+	Paths [][]string
+}
+
+// pathHandler shows the shortest chains of references from the
+// roots to an object.  Parameters are the object id, the number
+// k of paths to show, and strong=1 to ignore the roots that the
+// runtime keeps for its own bookkeeping.
+func pathHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	v := q["id"]
+	if len(v) != 1 {
+		http.Error(w, "id parameter missing", 405)
+		return
+	}
+	id, err := strconv.ParseUint(v[0], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return
+	}
+	if int(id) >= d.NumObjects() {
+		http.Error(w, "object not found", 405)
+		return
+	}
+	x := read.ObjId(id)
+	k := 5
+	if v := q.Get("k"); v != "" {
+		k, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, err.Error(), 405)
+			return
+		}
+		if k < 1 {
+			http.Error(w, "k must be at least 1", http.StatusBadRequest)
+			return
+		}
+	}
+	opts := []analysis.PathOption{analysis.MaxPaths(k)}
+	if q.Get("strong") == "1" {
+		opts = append(opts, analysis.ExcludeRoots(read.RootOther, read.RootFinalizer))
+	}
+
+	paths, err := analysis.RootPaths(d, x, opts...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	info := pathInfo{d.Addr(x), objLink(x), nil}
+	for _, p := range paths {
+		s := []string{rootSource(p.Root, p.Edges[0])}
+		for i, e := range p.Edges[1:] {
+			s = append(s, fmt.Sprintf("&rarr; %s %s", edgeSource(p.Edges[i].To, e), typeLink(d.Ft(p.Edges[i].To))))
+		}
+		last := p.Edges[len(p.Edges)-1]
+		s = append(s, fmt.Sprintf("&rarr; %s %s", edgeLink(last), typeLink(d.Ft(last.To))))
+		info.Paths = append(info.Paths, s)
+	}
+	if err := pathTemplate.Execute(w, info); err != nil {
+		log.Print(err)
+	}
+}
+
+// goroutines grouped by stack signature, computed on first use
+var (
+	goOnce   sync.Once
+	goGroups []analysis.GoGroup
+)
+
+// maxGoSamples is the number of goroutines the goroutines page
+// links to for each group.
+const maxGoSamples = 5
+
+type goGroupEntry struct {
+	Count   int
+	State   string
+	Waiting string
+	Stack   string
+	Created string
+	Samples string
+}
+
+type goGroupInfo struct {
+	Count  int
+	Groups []goGroupEntry
 }
 
 var goListTemplate = template.Must(template.New("golist").Parse(`
@@ -602,16 +1359,24 @@ border:1px solid grey;
 </head>
 <body>
 <tt>
-<h2>Goroutines</h2>
+<h2>Goroutines: {{.Count}} in {{len .Groups}} groups</h2>
 <table>
 <tr>
-<td>Name</td>
+<td>Count</td>
 <td>State</td>
+<td>Waiting since</td>
+<td>Stack</td>
+<td>Created by</td>
+<td>Goroutines</td>
 </tr>
-{{range .}}
+{{range .Groups}}
 <tr>
-<td>{{.Name}}</td>
+<td align=right>{{.Count}}</td>
 <td>{{.State}}</td>
+<td>{{.Waiting}}</td>
+<td>{{.Stack}}</td>
+<td>{{.Created}}</td>
+<td>{{.Samples}}</td>
 </tr>
 {{end}}
 </table>
@@ -620,48 +1385,101 @@ border:1px solid grey;
 </html>
 `))
 
+type goMemberInfo struct {
+	Group   goGroupEntry
+	Members []string
+}
+
+var goMembersTemplate = template.Must(template.New("gomembers").Parse(`
+<html>
+<head>
+<title>Goroutines</title>
+</head>
+<body>
+<tt>
+<h2>{{.Group.Count}} goroutines: {{.Group.State}}</h2>
+{{.Group.Stack}}
+<br>
+{{if .Group.Created}}created by {{.Group.Created}}{{end}}
+<h3>Goroutines</h3>
+{{range .Members}}
+{{.}}
+<br>
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+// goGroupEntryOf returns the display form of g.  i is the index of g
+// in goGroups.
+func goGroupEntryOf(i int, g *analysis.GoGroup) goGroupEntry {
+	e := goGroupEntry{Count: g.Count(), State: html.EscapeString(g.State)}
+	if g.MaxWait != 0 {
+		e.Waiting = fmt.Sprintf("%d..%d", g.MinWait, g.MaxWait)
+	}
+	var stack []string
+	for _, f := range g.Funcs {
+		stack = append(stack, html.EscapeString(f))
+	}
+	e.Stack = strings.Join(stack, "<br>")
+	if gr := g.Goroutines[0]; gr.CreatedBy != "" {
+		e.Created = html.EscapeString(fmt.Sprintf("%s at %s", gr.CreatedBy, srcPos(gr.CreatedFile, gr.CreatedLine)))
+	} else if g.Gopc != 0 {
+		e.Created = fmt.Sprintf("pc %x", g.Gopc)
+	}
+	var samples []string
+	for j, gr := range g.Goroutines {
+		if j == maxGoSamples {
+			samples = append(samples, fmt.Sprintf("<a href=goroutines?group=%d>all %d</a>", i, g.Count()))
+			break
+		}
+		samples = append(samples, fmt.Sprintf("<a href=go?id=%x>%x</a>", gr.Addr, gr.Addr))
+	}
+	e.Samples = strings.Join(samples, " ")
+	return e
+}
+
 func goListHandler(w http.ResponseWriter, r *http.Request) {
-	var i []goListInfo
-	for _, g := range d.Goroutines {
-		name := fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", g.Addr, g.Addr)
-		var state string
-		switch g.Status {
-		case 0:
-			state = "idle"
-		case 1:
-			state = "runnable"
-		case 2:
-			// running - shouldn't happen
-			log.Fatal("found running goroutine in heap dump")
-		case 3:
-			state = "syscall"
-		case 4:
-			state = g.WaitReason
-		case 5:
-			state = "dead"
-		default:
-			log.Fatal("unknown goroutine status")
+	goOnce.Do(func() {
+		goGroups = analysis.GroupGoroutines(d)
+	})
+	q := r.URL.Query()
+	if v := q["group"]; len(v) == 1 {
+		i, err := strconv.Atoi(v[0])
+		if err != nil || i < 0 || i >= len(goGroups) {
+			http.Error(w, "group not found", 405)
+			return
 		}
-		i = append(i, goListInfo{name, state})
+		g := &goGroups[i]
+		info := goMemberInfo{Group: goGroupEntryOf(i, g)}
+		for _, gr := range g.Goroutines {
+			m := fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", gr.Addr, gr.Addr)
+			if gr.WaitSince != 0 {
+				m += fmt.Sprintf(" waiting since %d", gr.WaitSince)
+			}
+			info.Members = append(info.Members, m)
+		}
+		if err := goMembersTemplate.Execute(w, info); err != nil {
+			log.Print(err)
+		}
+		return
 	}
-	// sort by state
-	sort.Sort(ByState(i))
-	if err := goListTemplate.Execute(w, i); err != nil {
+	info := goGroupInfo{Count: len(d.Goroutines)}
+	for i := range goGroups {
+		info.Groups = append(info.Groups, goGroupEntryOf(i, &goGroups[i]))
+	}
+	if err := goListTemplate.Execute(w, info); err != nil {
 		log.Print(err)
 	}
 }
 
-type ByState []goListInfo
-
-func (a ByState) Len() int           { return len(a) }
-func (a ByState) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByState) Less(i, j int) bool { return a[i].State < a[j].State }
-
 type goInfo struct {
-	Addr   uint64
-	Obj    read.ObjId
-	State  string
-	Frames []string
+	Addr    uint64
+	Obj     read.ObjId
+	State   string
+	Created string
+	Frames  []string
 }
 
 var goTemplate = template.Must(template.New("go").Parse(`
@@ -683,6 +1501,7 @@ border:1px solid grey;
 <tt>
 <h2>Goroutine <a href=obj?id={{.Obj}}>{{printf "%x" .Addr}}</a></h2>
 <h3>{{.State}}</h3>
+<h3>Created by {{.Created}}</h3>
 <h3>Stack</h3>
 {{range .Frames}}
 {{.}}
@@ -720,26 +1539,19 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 	var i goInfo
 	i.Addr = g.Addr
 	i.Obj = d.FindObj(g.Addr)
-	switch g.Status {
-	case 0:
-		i.State = "idle"
-	case 1:
-		i.State = "runnable"
-	case 2:
-		// running - shouldn't happen
-		log.Fatal("found running goroutine in heap dump")
-	case 3:
-		i.State = "syscall"
-	case 4:
-		i.State = g.WaitReason
-	case 5:
-		i.State = "dead"
-	default:
-		log.Fatal("unknown goroutine status")
+	i.State = g.State()
+	if g.CreatedBy != "" {
+		i.Created = fmt.Sprintf("%s at %s", g.CreatedBy, srcPos(g.CreatedFile, g.CreatedLine))
+	} else {
+		i.Created = fmt.Sprintf("pc %x", g.Gopc)
 	}
 
 	for f := g.Bos; f != nil; f = f.Parent {
-		i.Frames = append(i.Frames, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>", f.Addr, f.Depth, f.Name))
+		s := fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>", f.Addr, f.Depth, f.Name)
+		if pos := srcPos(f.File, f.Line); pos != "" {
+			s += " at " + pos
+		}
+		i.Frames = append(i.Frames, s)
 	}
 
 	if err := goTemplate.Execute(w, i); err != nil {
@@ -750,6 +1562,7 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 type frameInfo struct {
 	Addr      uint64
 	Name      string
+	Pos       string
 	Depth     uint64
 	Goroutine string
 	Vars      []Field
@@ -773,6 +1586,7 @@ border:1px solid grey;
 <body>
 <tt>
 <h2>Frame {{.Name}}</h2>
+{{if .Pos}}<h3>At {{.Pos}}</h3>{{end}}
 <h3>In {{.Goroutine}}</h3>
 <h3>Variables</h3>
 <table>
@@ -832,6 +1646,7 @@ func frameHandler(w http.ResponseWriter, r *http.Request) {
 	var i frameInfo
 	i.Addr = f.Addr
 	i.Name = f.Name
+	i.Pos = srcPos(f.File, f.Line)
 	i.Depth = f.Depth
 	i.Goroutine = fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", f.Goroutine.Addr, f.Goroutine.Addr)
 
@@ -857,7 +1672,7 @@ func heapdumpHandler(w http.ResponseWriter, r *http.Request) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr,
-		"usage: hview heapdump [executable]\n")
+		"usage: hview [-base oldheapdump] heapdump[.gz|.bz2] [executable]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -881,14 +1696,40 @@ func main() {
 	}
 
 	fmt.Println("Loading...")
-	d = read.Read(dump, exec)
+	var err error
+	var opts []read.Option
+	if *mmap {
+		opts = append(opts, read.Mmap())
+	}
+	if *cache {
+		opts = append(opts, read.Cache(""))
+	}
+	if *finroots {
+		opts = append(opts, read.FinalizerRoots())
+	}
+	opts = append(opts, read.StringLimit(*strLimit))
+	d, err = read.Open(dump, exec, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *baseDump != "" {
+		base, err = read.Open(*baseDump, exec, opts...)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	fmt.Println("Analyzing...")
 	prepare()
+	if base != nil {
+		deltas = analysis.DiffTypes(base, d, baseTypeRetained(), typeRetained)
+	}
 
 	fmt.Println("Ready.  Point your browser to localhost" + *httpAddr)
 	http.HandleFunc("/", mainHandler)
 	http.HandleFunc("/obj", objHandler)
+	http.HandleFunc("/path", pathHandler)
 	http.HandleFunc("/type", typeHandler)
 	http.HandleFunc("/histo", histoHandler)
 	http.HandleFunc("/globals", globalsHandler)
@@ -896,59 +1737,53 @@ func main() {
 	http.HandleFunc("/go", goHandler)
 	http.HandleFunc("/frame", frameHandler)
 	http.HandleFunc("/others", othersHandler)
+	http.HandleFunc("/diff", diffHandler)
+	http.HandleFunc("/dups", dupsHandler)
+	http.HandleFunc("/overalloc", overallocHandler)
+	http.HandleFunc("/slack", slackHandler)
 	http.HandleFunc("/heapdump", heapdumpHandler)
 	if err := http.ListenAndServe(*httpAddr, nil); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// Map from object ID to list of objects that refer to that object.
-// It is split in two parts for efficiency.  If an object x has <= 1
-// inbound edge, we store it in ref1[x].  Otherwise, it is stored in ref2[x].
-// Since most objects have only one incoming reference,
-// ref2 ends up small.
-var ref1 []read.ObjId
-var ref2 map[read.ObjId][]read.ObjId
+// returns an html string representing the source of an Edge from a root
+func rootSource(root *read.Root, e read.Edge) string {
+	switch root.Kind {
+	case read.RootStack:
+		f := root.Frame
+		return fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>.%s", f.Addr, f.Depth, f.Name, e.FieldName)
+	case read.RootGlobal:
+		return "global " + e.FieldName
+	case read.RootQFinalizer:
+		return "queued finalizer." + e.FieldName
+	default:
+		return root.Description
+	}
+}
 
-func getReferrers(x read.ObjId) []string {
-	var r []string
-	if y := ref1[x]; y != read.ObjNil {
-		for _, e := range d.Edges(y) {
-			if e.To == x {
-				r = append(r, edgeSource(y, e))
-			}
-		}
-		for _, y := range ref2[x] {
-			for _, e := range d.Edges(y) {
-				if e.To == x {
-					r = append(r, edgeSource(y, e))
-				}
-			}
-		}
+func getReferrers(x read.ObjId) ([]string, error) {
+	refs, err := d.ReadReferrers(x)
+	if err != nil {
+		return nil, err
 	}
-	for _, s := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range s.Edges {
-			if e.To != x {
-				continue
-			}
-			r = append(r, "global "+e.FieldName)
+	var r []string
+	for _, ref := range refs {
+		e := ref.Edge
+		if ref.From != read.ObjNil {
+			r = append(r, edgeSource(ref.From, e))
+			continue
 		}
+		r = append(r, rootSource(ref.Root, e))
 	}
-	for _, f := range d.Frames {
+	for _, f := range d.Finalizers {
 		for _, e := range f.Edges {
 			if e.To == x {
-				r = append(r, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>.%s", f.Addr, f.Depth, f.Name, e.FieldName))
-			}
-		}
-	}
-	for _, s := range d.Otherroots {
-		for _, e := range s.Edges {
-			if e.To == x {
-				r = append(r, s.Description)
+				r = append(r, "finalizer."+e.FieldName)
 			}
 		}
 	}
-	return r
+	return r, nil
 }
 
 type bucket struct {
@@ -971,155 +1806,121 @@ func prepare() {
 		byType[tid] = b
 	}
 
-	// compute referrers
-	ref1 = make([]read.ObjId, d.NumObjects())
-	for i := 0; i < d.NumObjects(); i++ {
-		ref1[i] = read.ObjNil
-	}
-	ref2 = map[read.ObjId][]read.ObjId{}
-	for i := 0; i < d.NumObjects(); i++ {
-		x := read.ObjId(i)
-		for _, e := range d.Edges(x) {
-			r := ref1[e.To]
-			if r == read.ObjNil {
-				ref1[e.To] = x
-			} else if x != r {
-				s := ref2[e.To]
-				if len(s) == 0 || x != s[len(s)-1] {
-					ref2[e.To] = append(s, x)
-				}
-			}
+	// build the referrers index now rather than on the first request
+	if d.NumObjects() > 0 {
+		if _, err := d.ReadReferrers(0); err != nil {
+			log.Fatal(err)
 		}
 	}
 
+	if loadPrepared() {
+		return
+	}
 	dom()
+	savePrepared()
 }
 
-// map from object ID to the size of the heap that is dominated by that object.
-var domsize []uint64
+// Name of the section of the dump's index file
+// where we keep the results of prepare.
+const dominatorsSection = "hview.dominators"
 
-func dom() {
-	fmt.Println("Computing dominators...")
-	n := d.NumObjects()
-
-	// make list of roots
-	// TODO: have loader compute this?
-	roots := map[read.ObjId]struct{}{}
-	for _, s := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range s.Edges {
-			roots[e.To] = struct{}{}
-		}
+// loadPrepared sets domsize and typeRetained from the dump's index
+// file.  It reports whether they were there.
+func loadPrepared() bool {
+	db, ok := d.CacheLoad(dominatorsSection)
+	if !ok {
+		return false
 	}
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			roots[e.To] = struct{}{}
-		}
+	ds, db, ok := decodeSizes(db, d.NumObjects()+1)
+	if !ok {
+		return false
 	}
-	for _, x := range d.Otherroots {
-		for _, e := range x.Edges {
-			roots[e.To] = struct{}{}
-		}
+	tr, db, ok := decodeSizes(db, len(d.FTList))
+	if !ok || len(db) != 0 {
+		return false
 	}
+	domsize, typeRetained = ds, tr
+	return true
+}
 
-	// compute postorder traversal
-	// object states:
-	// 0 - not seen yet
-	// 1 - seen, added to queue, not yet expanded children
-	// 2 - seen, already expanded children
-	// 3 - added to postorder
-	postorder := make([]read.ObjId, 0, n)
-	postnum := make([]int, n+1)
-	state := make([]byte, n)
-	var q []read.ObjId // stack of work to do, holds state 1 and 2 objects
-	for x := range roots {
-		if state[x] != 0 {
-			if state[x] != 3 {
-				log.Fatal("bad state found")
-			}
-			continue
-		}
-		state[x] = 1
-		q = q[:0]
-		q = append(q, x)
-		for len(q) > 0 {
-			y := q[len(q)-1]
-			if state[y] == 2 {
-				state[y] = 3
-				q = q[:len(q)-1]
-				postnum[y] = len(postorder)
-				postorder = append(postorder, y)
-			} else {
-				if state[y] != 1 {
-					log.Fatal("bad state")
-				}
-				state[y] = 2
-				for _, e := range d.Edges(y) {
-					z := e.To
-					if state[z] == 0 {
-						state[z] = 1
-						q = append(q, z)
-					}
-				}
-			}
-		}
+// savePrepared saves domsize and typeRetained in the dump's index file.
+func savePrepared() {
+	db := encodeSizes(nil, domsize)
+	db = encodeSizes(db, typeRetained)
+	if err := d.CacheStore(dominatorsSection, db); err != nil {
+		log.Print(err)
 	}
-	postnum[n] = n // virtual start node
+}
 
-	// compute immediate dominators
-	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
-	idom := make([]read.ObjId, n+1)
-	for i := 0; i < n; i++ {
-		idom[i] = read.ObjNil
-	}
-	idom[n] = read.ObjId(n)
-	for r := range roots {
-		idom[r] = read.ObjId(n)
-	}
-	var redges []read.ObjId
-	change := true
-	for change {
-		change = false
-		for i := len(postorder) - 1; i >= 0; i-- {
-			x := postorder[i]
-			// get list of incoming edges
-			redges = redges[:0]
-			if ref1[x] != read.ObjNil {
-				redges = append(redges, ref1[x])
-				redges = append(redges, ref2[x]...)
-			}
-			a := read.ObjNil
-			for _, b := range redges {
-				if idom[b] == read.ObjNil {
-					continue
-				}
-				if a == read.ObjNil {
-					a = b
-					continue
-				}
-				for a != b {
-					if postnum[a] < postnum[b] {
-						a = idom[a]
-					} else {
-						b = idom[b]
-					}
-				}
-			}
-			if _, ok := roots[x]; ok {
-				a = read.ObjId(n)
-			}
-			if a != idom[x] {
-				idom[x] = a
-				change = true
-			}
+// encodeSizes appends sizes to b as uvarints.
+func encodeSizes(b []byte, sizes []uint64) []byte {
+	for _, x := range sizes {
+		b = binary.AppendUvarint(b, x)
+	}
+	return b
+}
+
+// decodeSizes decodes n sizes written by encodeSizes from the start
+// of b, and returns them and the rest of b.  It reports false if b
+// is too short.
+func decodeSizes(b []byte, n int) ([]uint64, []byte, bool) {
+	sizes := make([]uint64, n)
+	for i := range sizes {
+		x, k := binary.Uvarint(b)
+		if k <= 0 {
+			return nil, nil, false
 		}
+		sizes[i] = x
+		b = b[k:]
 	}
+	return sizes, b, true
+}
 
+// map from object ID to the size of the heap that is dominated by that object.
+var domsize []uint64
+
+// map from full type ID to the size of the heap dominated by objects of
+// that type, for the diff page.
+var typeRetained []uint64
+
+func dom() {
+	fmt.Println("Computing dominators...")
+	t, err := analysis.Dominators(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := d.NumObjects()
 	domsize = make([]uint64, n+1)
-	for _, x := range postorder {
-		domsize[x] += d.Size(x)
-		domsize[idom[x]] += domsize[x]
+	for i := 0; i < n; i++ {
+		domsize[i] = t.Retained(read.ObjId(i))
 	}
+	domsize[n] = t.Retained(read.ObjNil)
 	// Note: unreachable objects will have domsize of 0.
+	typeRetained = t.TypeRetained()
+}
+
+// Name of the section of the base dump's index file where we keep
+// its retained sizes by type.
+const baseSection = "hview.typeretained"
+
+// baseTypeRetained returns the retained sizes by type of the base dump,
+// from its index file if they are there.
+func baseTypeRetained() []uint64 {
+	if b, ok := base.CacheLoad(baseSection); ok {
+		if tr, b, ok := decodeSizes(b, len(base.FTList)); ok && len(b) == 0 {
+			return tr
+		}
+	}
+	fmt.Println("Computing dominators of the base dump...")
+	t, err := analysis.Dominators(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tr := t.TypeRetained()
+	if err := base.CacheStore(baseSection, encodeSizes(nil, tr)); err != nil {
+		log.Print(err)
+	}
+	return tr
 }
 
 func readPtr(b []byte) uint64 {
@@ -1129,7 +1930,7 @@ func readPtr(b []byte) uint64 {
 	case 8:
 		return d.Order.Uint64(b)
 	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
+		log.Fatalf("unsupported PtrSize=%d", d.PtrSize)
 		return 0
 	}
 }
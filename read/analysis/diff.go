@@ -0,0 +1,125 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/randall77/hprof/read"
+)
+
+// TypeStats summarizes the objects of one type in a dump.
+type TypeStats struct {
+	Count    int
+	Bytes    uint64
+	Retained uint64 // heap dominated by objects of the type
+}
+
+// A TypeDelta compares the objects of one type in two dumps.
+type TypeDelta struct {
+	Name     string
+	Old, New TypeStats
+}
+
+// Count returns the growth in the number of objects.
+func (t *TypeDelta) Count() int {
+	return t.New.Count - t.Old.Count
+}
+
+// Bytes returns the growth in the size of the objects.
+func (t *TypeDelta) Bytes() int64 {
+	return int64(t.New.Bytes - t.Old.Bytes)
+}
+
+// Retained returns the growth in the heap dominated by the objects.
+func (t *TypeDelta) Retained() int64 {
+	return int64(t.New.Retained - t.Old.Retained)
+}
+
+// DiffTypes compares two dumps of the same program, type by type.
+// Types are matched by name, because type addresses differ between
+// processes.  Retained sizes are taken from oldRetained and
+// newRetained, indexed by FullType id as returned by
+// DomTree.TypeRetained, which may be nil to leave them zero.  The
+// result is sorted by decreasing growth in bytes.
+func DiffTypes(old, new *read.Dump, oldRetained, newRetained []uint64) []TypeDelta {
+	index := map[string]int{}
+	var deltas []TypeDelta
+	add := func(d *read.Dump, retained []uint64, stats func(*TypeDelta) *TypeStats) {
+		for i, n := 0, d.NumObjects(); i < n; i++ {
+			ft := d.Ft(read.ObjId(i))
+			j, ok := index[ft.Name]
+			if !ok {
+				j = len(deltas)
+				index[ft.Name] = j
+				deltas = append(deltas, TypeDelta{Name: ft.Name})
+			}
+			s := stats(&deltas[j])
+			s.Count++
+			s.Bytes += ft.Size
+		}
+		// Types with no objects have no retained size,
+		// so every type with a retained size has an entry.
+		for id, r := range retained {
+			if r != 0 {
+				stats(&deltas[index[d.FTList[id].Name]]).Retained += r
+			}
+		}
+	}
+	add(old, oldRetained, func(t *TypeDelta) *TypeStats { return &t.Old })
+	add(new, newRetained, func(t *TypeDelta) *TypeStats { return &t.New })
+	sort.SliceStable(deltas, func(i, j int) bool {
+		return deltas[i].Bytes() > deltas[j].Bytes()
+	})
+	return deltas
+}
+
+// TypeRetained returns the size of the heap dominated by the objects
+// of each type, indexed by FullType id.  An object dominated by
+// another object of the same type is counted only once.
+func (t *DomTree) TypeRetained() []uint64 {
+	t.childOnce.Do(t.buildChildren)
+	d := t.d
+	r := make([]uint64, len(d.FTList))
+	active := make([]int, len(d.FTList)) // objects of each type on the stack
+	type frame struct {
+		v      uint32 // number of the object being explored
+		i, end uint32 // its unexplored children, in t.child
+	}
+	stack := []frame{{0, t.childOff[0], t.childOff[1]}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.i == f.end {
+			if f.v != 0 {
+				active[d.Ft(t.obj(f.v)).Id]--
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		w := t.child[f.i]
+		f.i++
+		id := d.Ft(t.obj(w)).Id
+		if active[id] == 0 {
+			r[id] += t.retained[w]
+		}
+		active[id]++
+		stack = append(stack, frame{w, t.childOff[w], t.childOff[w+1]})
+	}
+	return r
+}
+
+// NewObjects returns the objects of new that are not in old.  An
+// object is in old if old has an object of the same size and type
+// name at the same address, so this is only meaningful for two
+// dumps of one process.
+func NewObjects(old, new *read.Dump) []read.ObjId {
+	var r []read.ObjId
+	for i, n := 0, new.NumObjects(); i < n; i++ {
+		x := read.ObjId(i)
+		addr := new.Addr(x)
+		y := old.FindObj(addr)
+		if y != read.ObjNil && old.Addr(y) == addr && old.Size(y) == new.Size(x) && old.Ft(y).Name == new.Ft(x).Name {
+			continue
+		}
+		r = append(r, x)
+	}
+	return r
+}
@@ -0,0 +1,288 @@
+// Package analysis computes properties of a heap dump that depend
+// on its whole object graph.
+package analysis
+
+import (
+	"sync"
+
+	"github.com/randall77/hprof/read"
+)
+
+// A DomTree is the dominator tree of the object graph of a heap dump.
+// An object x dominates an object y if every path from the roots of
+// the heap to y goes through x.  The tree is rooted at a virtual root,
+// represented by read.ObjNil, that points to all the roots of the heap
+// (see read.Dump.Roots).  Objects that are not reachable from the roots
+// are not in the tree.
+type DomTree struct {
+	d *read.Dump
+
+	// Reachable objects are numbered in depth-first preorder from
+	// the virtual root, which is number 0.  All the other slices
+	// are indexed by that number.
+	pre      []uint32 // number of each object, 0 if unreachable
+	vertex   []uint32 // object with each number
+	idom     []uint32 // number of the immediate dominator
+	retained []uint64 // total size of the dominated objects
+
+	childOnce sync.Once
+	childOff  []uint32 // children of v are child[childOff[v]:childOff[v+1]]
+	child     []uint32
+}
+
+const none = ^uint32(0)
+
+// Dominators computes the dominator tree of d, using the semi-NCA
+// algorithm of Georgiadis.  It runs in near-linear time and keeps a
+// few words per object and per edge.  It fails with a
+// *read.ObjectError if an object's edges can't be read.
+func Dominators(d *read.Dump) (*DomTree, error) {
+	g := graph{
+		n: d.NumObjects(),
+		size: func(x uint32) uint64 {
+			return d.Size(read.ObjId(x))
+		},
+	}
+	for _, r := range d.Roots() {
+		for _, e := range r.Edges {
+			g.roots = append(g.roots, uint32(e.To))
+		}
+	}
+	var edges []read.Edge
+	g.succ = func(x uint32, dst []uint32) ([]uint32, error) {
+		var err error
+		edges, err = d.EdgesInto(read.ObjId(x), edges[:0])
+		for _, e := range edges {
+			dst = append(dst, uint32(e.To))
+		}
+		return dst, err
+	}
+	t, err := dominators(g)
+	if err != nil {
+		return nil, err
+	}
+	t.d = d
+	return t, nil
+}
+
+// A graph is the object graph of a heap dump, with objects
+// numbered from 0 to n-1.
+type graph struct {
+	n     int
+	roots []uint32 // successors of the virtual root
+	size  func(x uint32) uint64
+
+	// succ appends the successors of x to dst.
+	succ func(x uint32, dst []uint32) ([]uint32, error)
+}
+
+// dominators computes the dominator tree of g.
+func dominators(g graph) (*DomTree, error) {
+	t := &DomTree{pre: make([]uint32, g.n)}
+
+	// Number the reachable objects by a depth-first search from the
+	// virtual root, recording the tree parent and the successors of
+	// each object as we go.
+	t.vertex = []uint32{none}
+	parent := []uint32{0}
+	succ := append([]uint32(nil), g.roots...)
+	succOff := []int{0, len(succ)}
+	type frame struct {
+		v      uint32 // number of the object being explored
+		i, end int    // its unexplored successors, in succ
+	}
+	stack := []frame{{0, 0, len(succ)}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.i == f.end {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		x := succ[f.i]
+		f.i++
+		if t.pre[x] != 0 {
+			continue
+		}
+		v := uint32(len(t.vertex))
+		t.pre[x] = v
+		t.vertex = append(t.vertex, x)
+		parent = append(parent, f.v)
+		start := len(succ)
+		var err error
+		succ, err = g.succ(x, succ)
+		if err != nil {
+			return nil, err
+		}
+		succOff = append(succOff, len(succ))
+		stack = append(stack, frame{v, start, len(succ)})
+	}
+	n := len(t.vertex)
+
+	// Invert the successor lists into predecessor lists, by number.
+	predOff := make([]int, n+1)
+	for _, x := range succ {
+		predOff[t.pre[x]+1]++
+	}
+	for v := 1; v <= n; v++ {
+		predOff[v] += predOff[v-1]
+	}
+	pred := make([]uint32, len(succ))
+	fill := make([]int, n)
+	copy(fill, predOff)
+	for v := 0; v < n; v++ {
+		for _, x := range succ[succOff[v]:succOff[v+1]] {
+			w := t.pre[x]
+			pred[fill[w]] = uint32(v)
+			fill[w]++
+		}
+	}
+	succ, succOff, fill = nil, nil, nil
+
+	// Compute semidominators in reverse preorder, using a
+	// link-eval forest with path compression.
+	semi := make([]uint32, n)
+	label := make([]uint32, n)
+	ancestor := make([]uint32, n)
+	for v := range semi {
+		semi[v] = uint32(v)
+		label[v] = uint32(v)
+		ancestor[v] = none
+	}
+	var path []uint32
+	eval := func(v uint32) uint32 {
+		if ancestor[v] == none {
+			return v
+		}
+		path = path[:0]
+		for x := v; ancestor[ancestor[x]] != none; x = ancestor[x] {
+			path = append(path, x)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			x := path[i]
+			a := ancestor[x]
+			if semi[label[a]] < semi[label[x]] {
+				label[x] = label[a]
+			}
+			ancestor[x] = ancestor[a]
+		}
+		return label[v]
+	}
+	for w := n - 1; w > 0; w-- {
+		for _, v := range pred[predOff[w]:predOff[w+1]] {
+			if s := semi[eval(v)]; s < semi[w] {
+				semi[w] = s
+			}
+		}
+		ancestor[w] = parent[w]
+	}
+	pred, predOff, label, ancestor = nil, nil, nil, nil
+
+	// The immediate dominator of w is the nearest common ancestor,
+	// in the dominator tree, of its semidominator and its parent.
+	// Numbers are in preorder, so walk up from the parent until
+	// reaching a number no larger than the semidominator.
+	idom := parent
+	for w := 1; w < n; w++ {
+		x := idom[w]
+		for x > semi[w] {
+			x = idom[x]
+		}
+		idom[w] = x
+	}
+	t.idom = idom
+
+	// Dominators precede the objects they dominate in preorder,
+	// so retained sizes can be summed in reverse preorder.
+	t.retained = make([]uint64, n)
+	for w := n - 1; w > 0; w-- {
+		t.retained[w] += g.size(t.vertex[w])
+		t.retained[idom[w]] += t.retained[w]
+	}
+	return t, nil
+}
+
+// num returns the number of x in the tree, and whether x is in it.
+func (t *DomTree) num(x read.ObjId) (uint32, bool) {
+	if x == read.ObjNil {
+		return 0, true
+	}
+	v := t.pre[x]
+	return v, v != 0
+}
+
+func (t *DomTree) obj(v uint32) read.ObjId {
+	if v == 0 {
+		return read.ObjNil
+	}
+	return read.ObjId(t.vertex[v])
+}
+
+// Reachable reports whether x is reachable from the roots of the heap.
+func (t *DomTree) Reachable(x read.ObjId) bool {
+	_, ok := t.num(x)
+	return ok
+}
+
+// NumReachable returns the number of objects reachable from the
+// roots of the heap.
+func (t *DomTree) NumReachable() int {
+	return len(t.vertex) - 1
+}
+
+// IDom returns the immediate dominator of x.  It returns read.ObjNil
+// if x is dominated only by the virtual root, or is not reachable.
+func (t *DomTree) IDom(x read.ObjId) read.ObjId {
+	v, ok := t.num(x)
+	if !ok || v == 0 {
+		return read.ObjNil
+	}
+	return t.obj(t.idom[v])
+}
+
+// Children returns the objects immediately dominated by x, in
+// depth-first order.  Children(read.ObjNil) returns the objects
+// immediately dominated by the virtual root.
+func (t *DomTree) Children(x read.ObjId) []read.ObjId {
+	v, ok := t.num(x)
+	if !ok {
+		return nil
+	}
+	t.childOnce.Do(t.buildChildren)
+	var c []read.ObjId
+	for _, w := range t.child[t.childOff[v]:t.childOff[v+1]] {
+		c = append(c, t.obj(w))
+	}
+	return c
+}
+
+func (t *DomTree) buildChildren() {
+	n := len(t.vertex)
+	off := make([]uint32, n+1)
+	for w := 1; w < n; w++ {
+		off[t.idom[w]+1]++
+	}
+	for v := 1; v <= n; v++ {
+		off[v] += off[v-1]
+	}
+	child := make([]uint32, n-1)
+	fill := make([]uint32, n)
+	copy(fill, off)
+	for w := 1; w < n; w++ {
+		v := t.idom[w]
+		child[fill[v]] = uint32(w)
+		fill[v]++
+	}
+	t.childOff, t.child = off, child
+}
+
+// Retained returns the total size of the objects dominated by x,
+// including x itself.  This is the amount of memory that would be
+// freed if x were.  It returns 0 if x is not reachable.
+// Retained(read.ObjNil) is the size of all reachable objects.
+func (t *DomTree) Retained(x read.ObjId) uint64 {
+	v, ok := t.num(x)
+	if !ok {
+		return 0
+	}
+	return t.retained[v]
+}
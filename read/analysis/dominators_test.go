@@ -0,0 +1,115 @@
+package analysis
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/randall77/hprof/read"
+)
+
+// testGraph returns a graph of 12 objects, where object x has size
+// 1<<x, made of two diamonds below the roots 0 and 5, a cycle, an
+// object shared by both diamonds, and unreachable objects 9 and 10.
+//
+//	0 -> 1 -> 3 <-> 4 -> 11 <- 8 <- 6 <- 5
+//	0 -> 2 -> 3              8 <- 7 <- 5
+//	9 -> 0   10 -> 10
+func testGraph(t *testing.T) graph {
+	succs := [][]uint32{
+		0:  {1, 2},
+		1:  {3},
+		2:  {3},
+		3:  {4},
+		4:  {3, 11},
+		5:  {6, 7},
+		6:  {8},
+		7:  {8},
+		8:  {11},
+		9:  {0},
+		10: {10},
+		11: nil,
+	}
+	return graph{
+		n:     len(succs),
+		roots: []uint32{0, 5},
+		size:  func(x uint32) uint64 { return 1 << x },
+		succ: func(x uint32, dst []uint32) ([]uint32, error) {
+			if x == 9 || x == 10 {
+				t.Errorf("visited unreachable object %d", x)
+			}
+			return append(dst, succs[x]...), nil
+		},
+	}
+}
+
+func TestDominators(t *testing.T) {
+	dt, err := dominators(testGraph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := read.ObjNil // the virtual root
+	idom := map[read.ObjId]read.ObjId{
+		0: root, 1: 0, 2: 0, 3: 0, 4: 3, 5: root, 6: 5, 7: 5, 8: 5, 11: root,
+		9: root, 10: root,
+	}
+	for x, want := range idom {
+		if got := dt.IDom(x); got != want {
+			t.Errorf("IDom(%d) = %d, want %d", x, got, want)
+		}
+	}
+	// children in depth-first order, following edges in order
+	children := map[read.ObjId][]read.ObjId{
+		root: {0, 11, 5},
+		0:    {1, 3, 2},
+		3:    {4},
+		5:    {6, 8, 7},
+		4:    nil,
+		11:   nil,
+		9:    nil,
+	}
+	for x, want := range children {
+		if got := dt.Children(x); !reflect.DeepEqual(got, want) {
+			t.Errorf("Children(%d) = %v, want %v", x, got, want)
+		}
+	}
+	retained := map[read.ObjId]uint64{
+		root: 1<<0 | 1<<1 | 1<<2 | 1<<3 | 1<<4 | 1<<5 | 1<<6 | 1<<7 | 1<<8 | 1<<11,
+		0:    1<<0 | 1<<1 | 1<<2 | 1<<3 | 1<<4,
+		1:    1 << 1,
+		3:    1<<3 | 1<<4,
+		5:    1<<5 | 1<<6 | 1<<7 | 1<<8,
+		8:    1 << 8,
+		11:   1 << 11,
+		9:    0,
+		10:   0,
+	}
+	for x, want := range retained {
+		if got := dt.Retained(x); got != want {
+			t.Errorf("Retained(%d) = %d, want %d", x, got, want)
+		}
+	}
+	if n := dt.NumReachable(); n != 10 {
+		t.Errorf("NumReachable() = %d, want 10", n)
+	}
+	for _, x := range []read.ObjId{9, 10} {
+		if dt.Reachable(x) {
+			t.Errorf("Reachable(%d) = true", x)
+		}
+	}
+}
+
+func TestDominatorsError(t *testing.T) {
+	g := testGraph(t)
+	bad := errors.New("bad object")
+	succ := g.succ
+	g.succ = func(x uint32, dst []uint32) ([]uint32, error) {
+		if x == 8 {
+			return dst, bad
+		}
+		return succ(x, dst)
+	}
+	if _, err := dominators(g); err != bad {
+		t.Fatalf("dominators returned %v, want %v", err, bad)
+	}
+}
@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"hash/maphash"
+	"sort"
+
+	"github.com/randall77/hprof/read"
+)
+
+// A DupGroup is a set of identical copies of some data in the heap:
+// objects of one type with the same contents, or the bytes of equal
+// strings stored at different addresses.  Copies are matched by a
+// 64-bit hash of their contents, so there is a tiny chance that a
+// group holds data that only hashes the same.
+type DupGroup struct {
+	Type    string       // type of the objects, or "string"
+	Size    uint64       // bytes in each copy
+	Zero    bool         // the copies are all zero bytes
+	Objects []read.ObjId // object holding each copy
+	Value   string       // for strings, the contents (see read.StringLimit)
+}
+
+// Count returns the number of copies.
+func (g *DupGroup) Count() int {
+	return len(g.Objects)
+}
+
+// Wasted returns the bytes used by all but one of the copies.
+func (g *DupGroup) Wasted() uint64 {
+	return uint64(len(g.Objects)-1) * g.Size
+}
+
+// Duplicates finds the objects whose contents are identical to those
+// of another object of the same type, and the strings whose contents
+// are stored more than once in the heap.  Strings are found through
+// the string fields of the globals, the stack frames and the objects
+// of known type; strings sharing their bytes are not copies.  The
+// result has a group for each set of at least two copies, sorted by
+// decreasing waste.
+func Duplicates(d *read.Dump) ([]DupGroup, error) {
+	type key struct {
+		ft   int // FullType id, or -1 for strings
+		size uint64
+		hash uint64
+	}
+	seed := maphash.MakeSeed()
+	groups := map[key]*DupGroup{}
+	add := func(k key, x read.ObjId, typ string, b []byte) *DupGroup {
+		g := groups[k]
+		if g == nil {
+			g = &DupGroup{Type: typ, Size: k.size, Zero: allZero(b)}
+			groups[k] = g
+		}
+		g.Objects = append(g.Objects, x)
+		return g
+	}
+
+	// Objects, by type.
+	var buf []byte
+	for i, n := 0, d.NumObjects(); i < n; i++ {
+		x := read.ObjId(i)
+		b, err := d.ContentsInto(x, buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = b
+		ft := d.Ft(x)
+		add(key{ft.Id, ft.Size, maphash.Bytes(seed, b)}, x, ft.Name, b)
+	}
+
+	// Strings, by contents.
+	type str struct {
+		addr, len uint64
+	}
+	seen := map[str]bool{}
+	var sbuf []byte
+	addStrings := func(data []byte, fields []read.Field) error {
+		for _, f := range fields {
+			if f.Kind != read.FieldKindString || f.Offset+2*d.PtrSize > uint64(len(data)) {
+				continue
+			}
+			s := str{readPtr(d, data[f.Offset:]), readPtr(d, data[f.Offset+d.PtrSize:])}
+			if s.len == 0 || seen[s] {
+				continue
+			}
+			seen[s] = true
+			x := d.FindObj(s.addr)
+			if x == read.ObjNil {
+				continue // not in the heap
+			}
+			b, err := d.ContentsInto(x, sbuf)
+			if err != nil {
+				return err
+			}
+			sbuf = b
+			off := s.addr - d.Addr(x)
+			end := off + s.len
+			if end > uint64(len(b)) {
+				end = uint64(len(b))
+			}
+			b = b[off:end]
+			g := add(key{-1, uint64(len(b)), maphash.Bytes(seed, b)}, x, "string", b)
+			if len(g.Objects) == 1 {
+				g.Value, _ = d.StringValue(data, f)
+			}
+		}
+		return nil
+	}
+	for _, x := range []*read.Data{d.Data, d.Bss} {
+		if x == nil {
+			continue
+		}
+		if err := addStrings(x.Data, x.Fields); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range d.Frames {
+		if err := addStrings(f.Data, f.Fields); err != nil {
+			return nil, err
+		}
+	}
+	for i, n := 0, d.NumObjects(); i < n; i++ {
+		x := read.ObjId(i)
+		if !hasStrings(d.Ft(x)) {
+			continue
+		}
+		b, err := d.ContentsInto(x, buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = b
+		if err := addStrings(b, d.Ft(x).Fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var r []DupGroup
+	for _, g := range groups {
+		if len(g.Objects) > 1 {
+			r = append(r, *g)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Wasted() != r[j].Wasted() {
+			return r[i].Wasted() > r[j].Wasted()
+		}
+		if r[i].Type != r[j].Type {
+			return r[i].Type < r[j].Type
+		}
+		return r[i].Objects[0] < r[j].Objects[0]
+	})
+	return r, nil
+}
+
+func hasStrings(ft *read.FullType) bool {
+	for _, f := range ft.Fields {
+		if f.Kind == read.FieldKindString {
+			return true
+		}
+	}
+	return false
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// readPtr returns the pointer at the start of b.
+func readPtr(d *read.Dump, b []byte) uint64 {
+	if d.PtrSize == 4 {
+		return uint64(d.Order.Uint32(b))
+	}
+	return d.Order.Uint64(b)
+}
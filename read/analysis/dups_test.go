@@ -0,0 +1,30 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	d := testDump(t)
+	groups, err := Duplicates(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Repeat("dup", 8)
+	for i, g := range groups {
+		if i > 0 && g.Wasted() > groups[i-1].Wasted() {
+			t.Errorf("group %d wastes %d bytes, more than the %d of group %d", i, g.Wasted(), groups[i-1].Wasted(), i-1)
+		}
+		if g.Type != "string" || g.Value != want {
+			continue
+		}
+		// The four copies in main.dups.
+		if g.Count() != 4 || g.Size != uint64(len(want)) || g.Wasted() != 3*uint64(len(want)) || g.Zero {
+			t.Errorf("group of %q has %d copies of %d bytes wasting %d, zero %v, want 4 of %d wasting %d",
+				want, g.Count(), g.Size, g.Wasted(), g.Zero, len(want), 3*len(want))
+		}
+		return
+	}
+	t.Errorf("no group of strings %q", want)
+}
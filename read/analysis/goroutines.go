@@ -0,0 +1,68 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/randall77/hprof/read"
+)
+
+// A GoGroup is a set of goroutines with the same stack signature:
+// the same state, the same functions on the stack and the same go
+// statement creating them.
+type GoGroup struct {
+	State      string   // see read.GoRoutine.State
+	Funcs      []string // functions on the stack, innermost first
+	Gopc       uint64   // pc of the go statement
+	Goroutines []*read.GoRoutine
+
+	// MinWait and MaxWait are the earliest and latest WaitSince of
+	// the goroutines, in the runtime's clock.  Both are zero if the
+	// runtime didn't record when the goroutines started waiting.
+	MinWait, MaxWait uint64
+}
+
+// Count returns the number of goroutines in the group.
+func (g *GoGroup) Count() int {
+	return len(g.Goroutines)
+}
+
+// GroupGoroutines groups the goroutines of d by stack signature, as
+// the debug=1 goroutine profile of runtime/pprof does.  The result is
+// sorted by decreasing size.  Goroutines in a group are in dump order.
+func GroupGoroutines(d *read.Dump) []GoGroup {
+	type key struct {
+		state, funcs string
+		gopc         uint64
+	}
+	groups := map[key]*GoGroup{}
+	var order []*GoGroup
+	for _, g := range d.Goroutines {
+		var funcs []string
+		for f := g.Bos; f != nil; f = f.Parent {
+			funcs = append(funcs, f.Name)
+		}
+		k := key{g.State(), strings.Join(funcs, "\n"), g.Gopc}
+		gg := groups[k]
+		if gg == nil {
+			gg = &GoGroup{State: k.state, Funcs: funcs, Gopc: g.Gopc, MinWait: g.WaitSince}
+			groups[k] = gg
+			order = append(order, gg)
+		}
+		gg.Goroutines = append(gg.Goroutines, g)
+		if g.WaitSince < gg.MinWait {
+			gg.MinWait = g.WaitSince
+		}
+		if g.WaitSince > gg.MaxWait {
+			gg.MaxWait = g.WaitSince
+		}
+	}
+	r := make([]GoGroup, len(order))
+	for i, g := range order {
+		r[i] = *g
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Count() > r[j].Count()
+	})
+	return r
+}
@@ -0,0 +1,71 @@
+package analysis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/randall77/hprof/read"
+)
+
+// The tests read a heap dump written by ../testdata/dumper.go, which
+// TestMain builds into testExec.
+var (
+	testDir  string // removed when the tests are done
+	testExec string // "" if it couldn't be built
+	buildErr error
+
+	testDumpOnce sync.Once
+	testDumpName string
+	testDumpErr  error
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "hprof-analysis-test")
+	if err != nil {
+		panic(err)
+	}
+	testDir = dir
+	if gocmd, err := exec.LookPath("go"); err != nil {
+		buildErr = err
+	} else {
+		exe := filepath.Join(dir, "dumper")
+		out, err := exec.Command(gocmd, "build", "-o", exe, filepath.Join("..", "testdata", "dumper.go")).CombinedOutput()
+		if err != nil {
+			buildErr = fmt.Errorf("building dumper: %v\n%s", err, out)
+		} else {
+			testExec = exe
+		}
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testDump opens a heap dump shared by the tests, with the dumper as
+// its executable, and closes it when the test is done.
+func testDump(t *testing.T) *read.Dump {
+	t.Helper()
+	if testExec == "" {
+		t.Skipf("no dumper: %v", buildErr)
+	}
+	testDumpOnce.Do(func() {
+		testDumpName = filepath.Join(testDir, "shared.dump")
+		if out, err := exec.Command(testExec, testDumpName).CombinedOutput(); err != nil {
+			testDumpErr = fmt.Errorf("dumper: %v\n%s", err, out)
+		}
+	})
+	if testDumpErr != nil {
+		t.Fatal(testDumpErr)
+	}
+	d, err := read.Open(testDumpName, testExec, read.Logger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
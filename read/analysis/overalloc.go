@@ -0,0 +1,180 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/randall77/hprof/read"
+)
+
+// An Overalloc totals the memory allocated but not used by the
+// slices, maps or channels held in one field of one type.
+type Overalloc struct {
+	Type   string // type holding the field; "global" for globals, the function for locals
+	Field  string // field name, with array indexes replaced by []
+	Kind   string // "slice", "map" or "chan"
+	Count  int    // number of values
+	Len    uint64 // elements in use (entries, for maps)
+	Cap    uint64 // elements there is room for
+	Bytes  uint64 // size of the backing arrays, or of the maps or channels with their buckets or buffers
+	Wasted uint64 // bytes of those in unused capacity
+
+	// Rounding is the bytes of the backing arrays past their
+	// capacity, added by rounding up to a size class.  It is 0
+	// for maps and channels.
+	Rounding uint64
+}
+
+// Overallocation finds the memory wasted by unused capacity in the
+// slices, maps and channels held by the globals, the stack frames and
+// the objects of known type, grouped by the type and field holding
+// them.
+//
+// For a slice, the waste is its spare capacity, s[len(s):cap(s)];
+// the rest of its backing array object past cap(s) is counted as
+// rounding.  A backing array shared by several slices is counted
+// once, for the first slice found.  For a
+// map, it is the share of its buckets in empty slots, and for a
+// channel the share of its buffer in empty slots.  Maps and channels
+// are found through the pointers to them.  Slices need the element
+// sizes from the executable.  The result is sorted by decreasing
+// waste.
+func Overallocation(d *read.Dump) ([]Overalloc, error) {
+	type key struct {
+		typ, field, kind string
+	}
+	groups := map[key]*Overalloc{}
+	seen := map[read.ObjId]bool{}
+	add := func(typ, field, kind string, x read.ObjId, n, c, bytes, wasted, rounding uint64) {
+		seen[x] = true
+		k := key{typ, indexFree(field), kind}
+		g := groups[k]
+		if g == nil {
+			g = &Overalloc{Type: k.typ, Field: k.field, Kind: k.kind}
+			groups[k] = g
+		}
+		g.Count++
+		g.Len += n
+		g.Cap += c
+		g.Bytes += bytes
+		g.Wasted += wasted
+		g.Rounding += rounding
+	}
+
+	scan := func(typ string, data []byte, fields []read.Field) error {
+		for _, f := range fields {
+			if f.Kind != read.FieldKindSlice && f.Kind != read.FieldKindPtr || f.Offset+d.PtrSize > uint64(len(data)) {
+				continue
+			}
+			p := readPtr(d, data[f.Offset:])
+			x := d.FindObj(p)
+			if x == read.ObjNil || seen[x] {
+				continue
+			}
+			switch f.Kind {
+			case read.FieldKindSlice:
+				elem, ok := d.ElemSize(f)
+				if !ok || f.Offset+3*d.PtrSize > uint64(len(data)) {
+					continue
+				}
+				n := readPtr(d, data[f.Offset+d.PtrSize:])
+				c := readPtr(d, data[f.Offset+2*d.PtrSize:])
+				size := d.Size(x)
+				end := size - (p - d.Addr(x)) // bytes from the slice's start to the end of x
+				used, capBytes := n*elem, c*elem
+				if capBytes > end {
+					capBytes = end
+				}
+				if used > capBytes {
+					used = capBytes
+				}
+				add(typ, f.Name, "slice", x, n, c, size, capBytes-used, end-capBytes)
+			case read.FieldKindPtr:
+				if p != d.Addr(x) {
+					continue
+				}
+				if d.IsMap(x) {
+					m, err := d.Map(x)
+					if err != nil {
+						return err
+					}
+					var wasted uint64
+					if m.Slots > 0 {
+						wasted = m.BucketBytes * uint64(m.Slots-m.Count) / uint64(m.Slots)
+					}
+					add(typ, f.Name, "map", x, uint64(m.Count), uint64(m.Slots), d.Size(x)+m.BucketBytes, wasted, 0)
+				} else if d.IsChan(x) {
+					c, err := d.Chan(x)
+					if err != nil {
+						return err
+					}
+					// The buffer is in the header's object in go1.3, and
+					// since go1.4 when its elements have no pointers.
+					bytes := d.Size(x)
+					if y := d.FindObj(c.Buf); c.Cap > 0 && y != read.ObjNil && y != x {
+						bytes += d.Size(y)
+					}
+					add(typ, f.Name, "chan", x, c.Len, c.Cap, bytes, (c.Cap-c.Len)*c.ElemSize, 0)
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, x := range []*read.Data{d.Data, d.Bss} {
+		if x == nil {
+			continue
+		}
+		if err := scan("global", x.Data, x.Fields); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range d.Frames {
+		if err := scan(f.Name, f.Data, f.Fields); err != nil {
+			return nil, err
+		}
+	}
+	var buf []byte
+	for i, n := 0, d.NumObjects(); i < n; i++ {
+		x := read.ObjId(i)
+		ft := d.Ft(x)
+		if ft.Typ == nil {
+			continue // fields are only the pointer layout
+		}
+		b, err := d.ContentsInto(x, buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = b
+		if err := scan(ft.Typ.Name, b, ft.Fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var r []Overalloc
+	for _, g := range groups {
+		r = append(r, *g)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Wasted != r[j].Wasted {
+			return r[i].Wasted > r[j].Wasted
+		}
+		if r[i].Type != r[j].Type {
+			return r[i].Type < r[j].Type
+		}
+		return r[i].Field < r[j].Field
+	})
+	return r, nil
+}
+
+// indexFree replaces the array indexes in a field name by [],
+// so that the elements of an array are grouped together.
+func indexFree(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		if p != "" && strings.Trim(p, "0123456789") == "" {
+			parts[i] = "[]"
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,40 @@
+package analysis
+
+import "testing"
+
+func TestOverallocation(t *testing.T) {
+	d := testDump(t)
+	fields, err := Overallocation(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]Overalloc{}
+	for _, f := range fields {
+		if f.Type == "global" {
+			found[f.Field] = f
+		}
+	}
+	// main.s holds 100 strings in an array grown by append.
+	s, ok := found["main.s"]
+	if !ok {
+		t.Fatal("no main.s")
+	}
+	elem := 2 * d.PtrSize
+	if s.Kind != "slice" || s.Count != 1 || s.Len != 100 || s.Cap < 100 {
+		t.Errorf("main.s is %d %s of len %d, cap %d, want 1 slice of len 100", s.Count, s.Kind, s.Len, s.Cap)
+	}
+	if s.Wasted != (s.Cap-s.Len)*elem {
+		t.Errorf("main.s wastes %d bytes, want (cap-len)*%d = %d", s.Wasted, elem, (s.Cap-s.Len)*elem)
+	}
+	// The array may start after a malloc header, which is neither.
+	if b := s.Cap*elem + s.Rounding; b > s.Bytes || b+d.PtrSize < s.Bytes {
+		t.Errorf("main.s has %d bytes, want cap*%d + %d of rounding", s.Bytes, elem, s.Rounding)
+	}
+
+	// main.c buffers 2 of 4 strings.
+	if c, ok := found["main.c"]; !ok {
+		t.Error("no main.c")
+	} else if c.Kind != "chan" || c.Len != 2 || c.Cap != 4 || c.Wasted != 2*elem || c.Rounding != 0 {
+		t.Errorf("main.c is %+v, want a chan of len 2, cap 4 wasting %d bytes", c, 2*elem)
+	}
+}
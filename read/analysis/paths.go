@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"github.com/randall77/hprof/read"
+)
+
+// A Path is a chain of references that keeps an object alive.
+// Edges[0] is a reference from Root, and each later edge is a
+// reference from the object the previous edge points to.  The last
+// edge points to the object.
+type Path struct {
+	Root  *read.Root
+	Edges []read.Edge
+}
+
+// A PathOption modifies the search done by RootPaths.
+type PathOption func(*pathConfig)
+
+type pathConfig struct {
+	max     int
+	exclude map[read.RootKind]bool
+}
+
+// MaxPaths sets the number of paths RootPaths returns.  The default
+// is 1.  If k is less than 1, RootPaths returns no paths, as if the
+// object were not reachable.
+func MaxPaths(k int) PathOption {
+	return func(c *pathConfig) {
+		c.max = k
+	}
+}
+
+// ExcludeRoots ignores roots of the given kinds, so that RootPaths
+// only finds paths from the roots that matter.  For instance,
+// excluding RootOther and RootFinalizer finds what keeps an object
+// alive other than the runtime's own bookkeeping.
+func ExcludeRoots(kinds ...read.RootKind) PathOption {
+	return func(c *pathConfig) {
+		for _, k := range kinds {
+			c.exclude[k] = true
+		}
+	}
+}
+
+// RootPaths returns the shortest paths from the roots of the heap
+// to object x, shortest first.  No two paths start with the same root
+// reference.  It returns no paths if x is not reachable.  The search
+// uses the dump's referrers index (see read.Dump.ReadReferrers) and
+// visits each object at most once.
+func RootPaths(d *read.Dump, x read.ObjId, opts ...PathOption) ([]Path, error) {
+	c := &pathConfig{max: 1, exclude: map[read.RootKind]bool{}}
+	for _, o := range opts {
+		o(c)
+	}
+
+	// Search backwards from x, remembering for each object the
+	// edge leading from it one step closer to x.
+	type rootEdge struct {
+		root   *read.Root
+		offset uint64
+	}
+	next := map[read.ObjId]read.Edge{x: {To: read.ObjNil}}
+	seen := map[rootEdge]bool{}
+	var paths []Path
+	q := []read.ObjId{x}
+	for len(q) > 0 && len(paths) < c.max {
+		y := q[0]
+		q = q[1:]
+		refs, err := d.ReadReferrers(y)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range refs {
+			if r.From != read.ObjNil {
+				if _, ok := next[r.From]; !ok {
+					next[r.From] = r.Edge
+					q = append(q, r.From)
+				}
+				continue
+			}
+			k := rootEdge{r.Root, r.Edge.FromOffset}
+			if c.exclude[r.Root.Kind] || seen[k] {
+				continue
+			}
+			seen[k] = true
+			p := Path{Root: r.Root, Edges: []read.Edge{r.Edge}}
+			for e := next[y]; e.To != read.ObjNil; e = next[e.To] {
+				p.Edges = append(p.Edges, e)
+			}
+			paths = append(paths, p)
+			if len(paths) == c.max {
+				break
+			}
+		}
+	}
+	return paths, nil
+}
+
+// ShortestPaths returns a shortest path from the roots of the heap to
+// each object in xs, in the same order.  The path of an object that is
+// not reachable has a nil Root.  Unlike calling RootPaths for each
+// object, it does a single breadth-first search forward from the
+// roots, visiting each object at most once however many objects it is
+// given.  Only the ExcludeRoots option applies.
+func ShortestPaths(d *read.Dump, xs []read.ObjId, opts ...PathOption) ([]Path, error) {
+	c := &pathConfig{max: 1, exclude: map[read.RootKind]bool{}}
+	for _, o := range opts {
+		o(c)
+	}
+
+	// parent holds for each object reached the object whose edge
+	// reached it first, plus one, or -1 if that edge is from a root.
+	type rootEdge struct {
+		root *read.Root
+		edge read.Edge
+	}
+	parent := make([]read.ObjId, d.NumObjects())
+	fromRoot := map[read.ObjId]rootEdge{}
+	var q []read.ObjId
+	roots := d.Roots()
+	for i := range roots {
+		r := &roots[i]
+		if c.exclude[r.Kind] {
+			continue
+		}
+		for _, e := range r.Edges {
+			if parent[e.To] == 0 {
+				parent[e.To] = -1
+				fromRoot[e.To] = rootEdge{r, e}
+				q = append(q, e.To)
+			}
+		}
+	}
+	want := map[read.ObjId]bool{}
+	for _, x := range xs {
+		want[x] = true
+	}
+	left := len(want)
+	var edges []read.Edge
+	for i := 0; i < len(q) && left > 0; i++ {
+		y := q[i]
+		if want[y] {
+			left--
+		}
+		var err error
+		if edges, err = d.EdgesInto(y, edges[:0]); err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			if parent[e.To] == 0 {
+				parent[e.To] = y + 1
+				q = append(q, e.To)
+			}
+		}
+	}
+
+	paths := make([]Path, len(xs))
+	for i, x := range xs {
+		if parent[x] == 0 {
+			continue
+		}
+		chain := []read.ObjId{x}
+		for y := x; parent[y] > 0; {
+			y = parent[y] - 1
+			chain = append(chain, y)
+		}
+		r := fromRoot[chain[len(chain)-1]]
+		p := Path{Root: r.root, Edges: []read.Edge{r.edge}}
+		for j := len(chain) - 1; j > 0; j-- {
+			var err error
+			if edges, err = d.EdgesInto(chain[j], edges[:0]); err != nil {
+				return nil, err
+			}
+			for _, e := range edges {
+				if e.To == chain[j-1] {
+					p.Edges = append(p.Edges, e)
+					break
+				}
+			}
+		}
+		paths[i] = p
+	}
+	return paths, nil
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/randall77/hprof/read"
+)
+
+// TestShortestPaths checks that ShortestPaths finds paths as short as
+// those RootPaths finds for each object on its own.
+func TestShortestPaths(t *testing.T) {
+	d := testDump(t)
+	var xs []read.ObjId
+	for i, n := 0, d.NumObjects(); i < n; i += n/50 + 1 {
+		xs = append(xs, read.ObjId(i))
+	}
+	paths, err := ShortestPaths(d, xs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != len(xs) {
+		t.Fatalf("%d paths for %d objects", len(paths), len(xs))
+	}
+	for i, x := range xs {
+		p := paths[i]
+		want, err := RootPaths(d, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) == 0 {
+			if p.Root != nil {
+				t.Errorf("path to unreachable object %d from %s", x, p.Root.Description)
+			}
+			continue
+		}
+		if p.Root == nil {
+			t.Errorf("no path to object %d, want one from %s", x, want[0].Root.Description)
+			continue
+		}
+		if len(p.Edges) != len(want[0].Edges) {
+			t.Errorf("path to object %d has %d edges, want %d", x, len(p.Edges), len(want[0].Edges))
+		}
+		if p.Edges[len(p.Edges)-1].To != x {
+			t.Errorf("path to object %d ends at %d", x, p.Edges[len(p.Edges)-1].To)
+		}
+		for j := 1; j < len(p.Edges); j++ {
+			edges, err := d.ReadEdges(p.Edges[j-1].To)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, e := range edges {
+				found = found || e == p.Edges[j]
+			}
+			if !found {
+				t.Errorf("path to object %d: edge %d is not from object %d", x, j, p.Edges[j-1].To)
+			}
+		}
+	}
+}
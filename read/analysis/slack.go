@@ -0,0 +1,124 @@
+package analysis
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/randall77/hprof/read"
+)
+
+// ErrNoObjectTypes is returned by SizeClassSlack for dumps that
+// don't record the types of objects.
+var ErrNoObjectTypes = errors.New("dump has no object types; only go1.3 dumps record them")
+
+// A Slack totals the bytes the objects of one full type lose to
+// size-class rounding: the allocator rounds each object up to the
+// next size class, and the bytes past the end of the type go unused.
+type Slack struct {
+	Type     *read.FullType
+	TypeSize uint64 // bytes of the type in each object
+	Count    int
+	Slack    uint64 // bytes lost by all the objects
+
+	// Shrink is how many bytes smaller the type would have to be
+	// for its objects to fit the next smaller size class, and Saving
+	// how many bytes that would save.  Both are zero for arrays and
+	// for types that fit the smallest size class.
+	Shrink uint64
+	Saving uint64
+}
+
+// Percent returns the share of the objects' bytes lost to rounding.
+func (s *Slack) Percent() float64 {
+	return 100 * float64(s.Slack) / float64(uint64(s.Count)*s.Type.Size)
+}
+
+// SizeClassSlack compares the size of the objects of known type
+// with the size of their type.  The result is sorted by decreasing
+// slack.  Size classes are those of recent Go releases; older
+// releases had slightly different ones, which makes Shrink and
+// Saving approximate for their dumps.
+//
+// Only go1.3 dumps record the types of objects.  Since go1.4 they
+// record just the pointer layout, so for a dump without a single
+// typed object SizeClassSlack returns ErrNoObjectTypes.
+func SizeClassSlack(d *read.Dump) ([]Slack, error) {
+	byType := make([]*Slack, len(d.FTList))
+	typed := false
+	for i, n := 0, d.NumObjects(); i < n; i++ {
+		ft := d.Ft(read.ObjId(i))
+		if ft.Typ == nil {
+			continue
+		}
+		typed = true
+		if ft.Typ.Size == 0 {
+			continue
+		}
+		s := byType[ft.Id]
+		if s == nil {
+			s = &Slack{Type: ft}
+			switch ft.Kind {
+			case read.TypeKindObject:
+				s.TypeSize = ft.Typ.Size
+			case read.TypeKindArray:
+				s.TypeSize = ft.Size / ft.Typ.Size * ft.Typ.Size
+			default:
+				continue
+			}
+			byType[ft.Id] = s
+		}
+		s.Count++
+	}
+	if !typed {
+		return nil, ErrNoObjectTypes
+	}
+	var r []Slack
+	for _, s := range byType {
+		if s == nil || s.TypeSize >= s.Type.Size {
+			continue
+		}
+		s.Slack = uint64(s.Count) * (s.Type.Size - s.TypeSize)
+		if below := sizeClassBelow(s.Type.Size); s.Type.Kind == read.TypeKindObject && below > 0 && below < s.TypeSize {
+			s.Shrink = s.TypeSize - below
+			s.Saving = uint64(s.Count) * (s.Type.Size - below)
+		}
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Slack != r[j].Slack {
+			return r[i].Slack > r[j].Slack
+		}
+		return r[i].Type.Name < r[j].Type.Name
+	})
+	return r, nil
+}
+
+// sizeClasses are the object sizes the Go allocator rounds small
+// objects up to.  Larger objects are rounded up to whole pages.
+var sizeClasses = []uint64{
+	8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224,
+	240, 256, 288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896,
+	1024, 1152, 1280, 1408, 1536, 1792, 2048, 2304, 2688, 3072, 3200, 3456,
+	4096, 4864, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728, 10240, 10880,
+	12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760, 24576, 27264,
+	28672, 32768,
+}
+
+const pageSize = 8192
+
+// sizeClassBelow returns the largest allocation size smaller than
+// size, or 0 if there is none.
+func sizeClassBelow(size uint64) uint64 {
+	if max := sizeClasses[len(sizeClasses)-1]; size > max {
+		below := (size - 1) / pageSize * pageSize
+		if below < max {
+			below = max
+		}
+		return below
+	}
+	i := sort.Search(len(sizeClasses), func(i int) bool { return sizeClasses[i] >= size })
+	if i == 0 {
+		return 0
+	}
+	return sizeClasses[i-1]
+}
@@ -0,0 +1,12 @@
+package analysis
+
+import "testing"
+
+// TestSizeClassSlackNoTypes checks that SizeClassSlack reports the
+// missing object types of a dump from a recent release.
+func TestSizeClassSlackNoTypes(t *testing.T) {
+	d := testDump(t)
+	if s, err := SizeClassSlack(d); err != ErrNoObjectTypes {
+		t.Errorf("SizeClassSlack returned %d types and error %v, want %v", len(s), err, ErrNoObjectTypes)
+	}
+}
@@ -0,0 +1,1126 @@
+package read
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// A loaded Dump can be saved in an index file next to the dump, so
+// that later loads of the same dump skip parsing, Dwarf naming and
+// linking.  The index file is
+//	cacheMagic
+//	the stamp of the dump and executable it was built from
+//	a list of sections, each a name, a length, and that many bytes
+// The "dump" section holds the Dump itself, and the "edges" section
+// the outgoing edges of every object in compressed sparse row form.
+// Other sections hold results computed by clients of the Dump; see
+// CacheLoad.  Numbers
+// are uvarints except for section lengths and the object table,
+// which are fixed size little-endian.
+
+// cacheMagic must be changed whenever the index file layout changes.
+const cacheMagic = "go heap dump index 8\n"
+
+// Cache makes Open keep the loaded dump in the index file path, and
+// load it from there next time if the dump file and executable have
+// not changed since.  The index file also holds the edges of every
+// object, so a cached dump answers Edges without reading object
+// contents.  If path is empty, the index file is the dump file name
+// with ".idx" appended.  Failure to write the index is only logged.
+func Cache(path string) Option {
+	return func(c *config) {
+		c.cache = true
+		c.cachePath = path
+	}
+}
+
+// A cacheStamp identifies the inputs an index file was built from.
+type cacheStamp struct {
+	dumpSize  int64
+	dumpMtime int64
+	execName  string
+	execSize  int64
+	execMtime int64
+
+	// options that change the loaded dump
+	finalizerRoots bool
+}
+
+func makeStamp(dump os.FileInfo, execname string, c *config) (*cacheStamp, error) {
+	s := &cacheStamp{
+		dumpSize:       dump.Size(),
+		dumpMtime:      dump.ModTime().UnixNano(),
+		finalizerRoots: c.finalizerRoots,
+	}
+	if execname != "" {
+		fi, err := os.Stat(execname)
+		if err != nil {
+			return nil, err
+		}
+		s.execName, _ = filepath.Abs(execname)
+		s.execSize = fi.Size()
+		s.execMtime = fi.ModTime().UnixNano()
+	}
+	return s, nil
+}
+
+var errStaleCache = errors.New("index file is out of date")
+
+// readCache loads the Dump saved in the index file path.  Object
+// contents are read from r.
+func readCache(path string, stamp *cacheStamp, r io.ReaderAt, workers int) (*Dump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	cr := &myReader{r: bufio.NewReaderSize(f, 1<<16), size: fi.Size()}
+	magic := make([]byte, len(cacheMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil || string(magic) != cacheMagic {
+		return nil, errStaleCache
+	}
+	if s := readStamp(cr); cr.err != nil || s != *stamp {
+		return nil, errStaleCache
+	}
+
+	d := &Dump{r: r, cachePath: path, cacheStamp: *stamp}
+	for {
+		if _, err := cr.r.Peek(1); err == io.EOF {
+			break
+		}
+		name := readString(cr)
+		n := readSectionLen(cr)
+		if cr.err != nil {
+			return nil, fmt.Errorf("%s: %v", path, cr.err)
+		}
+		if name == "edges" {
+			b := readNBytes(cr, n)
+			if cr.err == nil && (d.PtrSize == 0 || !d.decodeEdges(b)) {
+				cr.fail(errors.New("bad edges section"))
+			}
+			if cr.err != nil {
+				return nil, fmt.Errorf("%s: %v", path, cr.err)
+			}
+			continue
+		}
+		if name != "dump" {
+			if d.cacheSections == nil {
+				d.cacheSections = map[string][]byte{}
+			}
+			d.cacheSections[name] = readNBytes(cr, n)
+			continue
+		}
+		start := cr.Count()
+		decodeDump(cr, d)
+		if cr.err == nil && uint64(cr.Count()-start) != n {
+			cr.fail(errors.New("dump section has wrong length"))
+		}
+		if cr.err != nil {
+			return nil, fmt.Errorf("%s: %v", path, cr.err)
+		}
+	}
+	if d.FTList == nil && d.NumObjects() > 0 || d.PtrSize == 0 {
+		return nil, fmt.Errorf("%s: no dump section", path)
+	}
+	buildIndex(d, workers)
+	linkGoroutineState(d)
+	return d, nil
+}
+
+// writeCache saves d in the index file path, along with its edges,
+// which it finds using up to workers goroutines.  Once the edges are
+// found, d uses them too.  The file is replaced atomically, so a
+// concurrent reader sees either the old or the new one.
+func writeCache(path string, stamp *cacheStamp, d *Dump, workers int) error {
+	edges, err := d.encodeEdges(workers)
+	if err != nil {
+		// Leave them to be decoded, and reported, on each use.
+		d.logf("not saving edges in index file: %v", err)
+		edges = nil
+	}
+	err = replaceFile(path, func(f *os.File) error {
+		w := &cacheWriter{w: bufio.NewWriterSize(f, 1<<16)}
+		w.w.WriteString(cacheMagic)
+		writeStamp(w, stamp)
+		w.putString("dump")
+		w.putSectionLen(0) // patched below
+		if err := w.w.Flush(); err != nil {
+			return err
+		}
+		start, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		encodeDump(w, d)
+		if err := w.w.Flush(); err != nil {
+			return err
+		}
+		end, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(end-start))
+		if _, err := f.WriteAt(b[:], start-8); err != nil {
+			return err
+		}
+		if edges != nil {
+			w.putSection("edges", edges)
+		}
+		return w.w.Flush()
+	})
+	if edges != nil {
+		d.decodeEdges(edges) // can't fail, we just encoded them
+	}
+	if err != nil {
+		return err
+	}
+	d.cachePath = path
+	d.cacheStamp = *stamp
+	return nil
+}
+
+// replaceFile atomically replaces the file path with one written by
+// write.  It writes a temporary file in the same directory and renames
+// it to path if write succeeds.
+func replaceFile(path string, write func(f *os.File) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// CacheLoad returns the data saved with CacheStore under name in
+// the index file the dump was loaded from (see Cache).  It reports
+// false if the dump is not cached or has no such section.
+func (d *Dump) CacheLoad(name string) ([]byte, bool) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	b, ok := d.cacheSections[name]
+	return b, ok
+}
+
+// CacheStore saves data under name in the dump's index file, so
+// results derived from the dump need not be recomputed when it is
+// loaded again.  The data replaces any saved earlier under the same
+// name.  It does nothing if the dump is not cached.
+func (d *Dump) CacheStore(name string, data []byte) error {
+	if d.cachePath == "" {
+		return nil
+	}
+	if name == "dump" || name == "edges" {
+		return fmt.Errorf("cache section name %s is reserved", name)
+	}
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	if err := replaceSection(d.cachePath, &d.cacheStamp, name, data); err != nil {
+		return err
+	}
+	if d.cacheSections == nil {
+		d.cacheSections = map[string][]byte{}
+	}
+	d.cacheSections[name] = data
+	return nil
+}
+
+// replaceSection rewrites the index file path with the section name
+// holding data, dropping any earlier section with that name.  It
+// fails with errStaleCache if the file no longer matches stamp, as
+// when another process has rebuilt it for a changed dump.
+func replaceSection(path string, stamp *cacheStamp, name string, data []byte) error {
+	old, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	fi, err := old.Stat()
+	if err != nil {
+		return err
+	}
+	r := &myReader{r: bufio.NewReaderSize(old, 1<<16), size: fi.Size()}
+	magic := make([]byte, len(cacheMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != cacheMagic {
+		return errStaleCache
+	}
+	if s := readStamp(r); r.err != nil || s != *stamp {
+		return errStaleCache
+	}
+	return replaceFile(path, func(f *os.File) error {
+		w := &cacheWriter{w: bufio.NewWriterSize(f, 1<<16)}
+		w.w.WriteString(cacheMagic)
+		writeStamp(w, stamp)
+		for {
+			if _, err := r.r.Peek(1); err == io.EOF {
+				break
+			}
+			s := readString(r)
+			n := readSectionLen(r)
+			if r.err != nil {
+				return fmt.Errorf("%s: %v", path, r.err)
+			}
+			if s == name {
+				r.Skip(int64(n))
+				continue
+			}
+			w.putString(s)
+			w.putSectionLen(n)
+			if _, err := io.CopyN(w.w, r, int64(n)); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("%s: %v", path, r.err)
+		}
+		w.putSection(name, data)
+		return w.w.Flush()
+	})
+}
+
+// encodeEdges encodes the outgoing edges of all the objects, found
+// using up to workers goroutines, for the edges section.  For each
+// object it writes the number of edges, then for each edge the index
+// of its field in the object's full type, the object it points to,
+// and the offset it points to, as uvarints.
+func (d *Dump) encodeEdges(workers int) ([]byte, error) {
+	n := d.NumObjects()
+	if workers < 1 || n < minParallel {
+		workers = 1
+	}
+	parts := make([][]byte, workers)
+	errs := make([]error, workers)
+	parallelFor(workers, workers, func(lo, hi int) {
+		for w := lo; w < hi; w++ {
+			parts[w], errs[w] = d.appendEncodedEdges(nil, n*w/workers, n*(w+1)/workers)
+		}
+	})
+	var b []byte
+	for w, p := range parts {
+		if errs[w] != nil {
+			return nil, errs[w]
+		}
+		b = append(b, p...)
+	}
+	return b, nil
+}
+
+// appendEncodedEdges appends to b the encoded edges of objects lo
+// through hi-1.
+func (d *Dump) appendEncodedEdges(b []byte, lo, hi int) ([]byte, error) {
+	var edges []Edge
+	for x := ObjId(lo); x < ObjId(hi); x++ {
+		var err error
+		edges, err = d.EdgesInto(x, edges[:0])
+		if err != nil {
+			return nil, err
+		}
+		b = binary.AppendUvarint(b, uint64(len(edges)))
+		// objEdges finds at most one edge per field, in field order.
+		fields := d.Ft(x).Fields
+		k := 0
+		for _, e := range edges {
+			for k < len(fields) && (fields[k].Name != e.FieldName || d.edgeOffset(fields[k]) != e.FromOffset) {
+				k++
+			}
+			if k == len(fields) {
+				return nil, &ObjectError{x, d.Addr(x), fmt.Errorf("no field for edge at offset %d", e.FromOffset)}
+			}
+			b = binary.AppendUvarint(b, uint64(k))
+			b = binary.AppendUvarint(b, uint64(e.To))
+			b = binary.AppendUvarint(b, e.ToOffset)
+			k++
+		}
+	}
+	return b, nil
+}
+
+// edgeOffset returns the offset of the pointer of field f.
+func (d *Dump) edgeOffset(f Field) uint64 {
+	if f.Kind == FieldKindEface || f.Kind == FieldKindIface {
+		return f.Offset + d.PtrSize
+	}
+	return f.Offset
+}
+
+// decodeEdges sets the edges of all the objects from b, as written by
+// encodeEdges.  It reports whether b was valid.
+func (d *Dump) decodeEdges(b []byte) bool {
+	n := d.NumObjects()
+	off := make([]int, n+1)
+	p := 0
+	next := func() (uint64, bool) {
+		v, k := binary.Uvarint(b[p:])
+		if k <= 0 {
+			return 0, false
+		}
+		p += k
+		return v, true
+	}
+	for x := 0; x < n; x++ {
+		off[x] = p
+		c, ok := next()
+		if !ok {
+			return false
+		}
+		nf := uint64(len(d.Ft(ObjId(x)).Fields))
+		for ; c > 0; c-- {
+			f, ok1 := next()
+			y, ok2 := next()
+			_, ok3 := next()
+			if !ok1 || !ok2 || !ok3 || f >= nf || y >= uint64(n) {
+				return false
+			}
+		}
+	}
+	off[n] = p
+	if p != len(b) {
+		return false
+	}
+	d.edgeOff, d.edgeData = off, b
+	return true
+}
+
+// cachedEdges appends the edges of object i, from the index file,
+// to dst.
+func (d *Dump) cachedEdges(i ObjId, dst []Edge) []Edge {
+	b := d.edgeData[d.edgeOff[i]:d.edgeOff[i+1]]
+	next := func() uint64 {
+		v, k := binary.Uvarint(b)
+		b = b[k:]
+		return v
+	}
+	fields := d.Ft(i).Fields
+	for c := next(); c > 0; c-- {
+		f := fields[next()]
+		y := ObjId(next())
+		dst = append(dst, Edge{y, d.edgeOffset(f), next(), f.Name})
+	}
+	return dst
+}
+
+func readStamp(r *myReader) cacheStamp {
+	var s cacheStamp
+	s.dumpSize = int64(readUint64(r))
+	s.dumpMtime = int64(readUint64(r))
+	s.execName = readString(r)
+	s.execSize = int64(readUint64(r))
+	s.execMtime = int64(readUint64(r))
+	s.finalizerRoots = readBool(r)
+	return s
+}
+
+func writeStamp(w *cacheWriter, s *cacheStamp) {
+	w.putUint64(uint64(s.dumpSize))
+	w.putUint64(uint64(s.dumpMtime))
+	w.putString(s.execName)
+	w.putUint64(uint64(s.execSize))
+	w.putUint64(uint64(s.execMtime))
+	w.putBool(s.finalizerRoots)
+}
+
+// cacheWriter writes the encodings that the read* functions read.
+// Errors are left in the underlying bufio.Writer for Flush to report.
+type cacheWriter struct {
+	w   *bufio.Writer
+	tmp [binary.MaxVarintLen64]byte
+}
+
+// putSection writes a complete section.
+func (w *cacheWriter) putSection(name string, data []byte) {
+	w.putString(name)
+	w.putSectionLen(uint64(len(data)))
+	w.w.Write(data)
+}
+
+func (w *cacheWriter) putSectionLen(n uint64) {
+	binary.LittleEndian.PutUint64(w.tmp[:8], n)
+	w.w.Write(w.tmp[:8])
+}
+
+func readSectionLen(r *myReader) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		r.fail(err)
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (w *cacheWriter) putUint64(x uint64) {
+	n := binary.PutUvarint(w.tmp[:], x)
+	w.w.Write(w.tmp[:n])
+}
+
+func (w *cacheWriter) putBool(b bool) {
+	if b {
+		w.w.WriteByte(1)
+	} else {
+		w.w.WriteByte(0)
+	}
+}
+
+func (w *cacheWriter) putBytes(b []byte) {
+	w.putUint64(uint64(len(b)))
+	w.w.Write(b)
+}
+
+func (w *cacheWriter) putString(s string) {
+	w.putUint64(uint64(len(s)))
+	w.w.WriteString(s)
+}
+
+// ObjIds and indexes that may be -1 are stored plus one.
+func (w *cacheWriter) putIndex(i int) {
+	w.putUint64(uint64(i + 1))
+}
+
+func readIndex(r *myReader) int {
+	return int(readUint64(r)) - 1
+}
+
+func (w *cacheWriter) putFields(fields []Field) {
+	w.putUint64(uint64(len(fields)))
+	for _, f := range fields {
+		w.putUint64(uint64(f.Kind))
+		w.putUint64(f.Offset)
+		w.putString(f.Name)
+		w.putString(f.BaseType)
+	}
+}
+
+func readCacheFields(r *myReader) []Field {
+	n := readCount(r)
+	if n == 0 {
+		return nil
+	}
+	fields := make([]Field, n)
+	for i := range fields {
+		fields[i].Kind = FieldKind(readUint64(r))
+		fields[i].Offset = readUint64(r)
+		fields[i].Name = readString(r)
+		fields[i].BaseType = readString(r)
+	}
+	return fields
+}
+
+func (w *cacheWriter) putEdges(edges []Edge) {
+	w.putUint64(uint64(len(edges)))
+	for _, e := range edges {
+		w.putIndex(int(e.To))
+		w.putUint64(e.FromOffset)
+		w.putUint64(e.ToOffset)
+		w.putString(e.FieldName)
+	}
+}
+
+func readCacheEdges(r *myReader) []Edge {
+	n := readCount(r)
+	if n == 0 {
+		return nil
+	}
+	edges := make([]Edge, n)
+	for i := range edges {
+		edges[i].To = ObjId(readIndex(r))
+		edges[i].FromOffset = readUint64(r)
+		edges[i].ToOffset = readUint64(r)
+		edges[i].FieldName = readString(r)
+	}
+	return edges
+}
+
+// readCount reads a slice length.  Every element takes at least a
+// byte, so a length longer than the file is corrupt.  Checking that
+// keeps a bad file from making us allocate a lot.
+func readCount(r *myReader) int {
+	n := readUint64(r)
+	if n > uint64(r.size) {
+		r.fail(errors.New("bad length"))
+		return 0
+	}
+	return int(n)
+}
+
+func readCacheRoots(r *myReader) []*OtherRoot {
+	var roots []*OtherRoot
+	for n := readCount(r); n > 0 && r.err == nil; n-- {
+		x := &OtherRoot{}
+		x.Description = readString(r)
+		x.Edges = readCacheEdges(r)
+		x.toaddr = readUint64(r)
+		roots = append(roots, x)
+	}
+	return roots
+}
+
+func (w *cacheWriter) putData(x *Data) {
+	w.putBool(x != nil)
+	if x == nil {
+		return
+	}
+	w.putUint64(x.Addr)
+	w.putBytes(x.Data)
+	w.putFields(x.Fields)
+	w.putEdges(x.Edges)
+}
+
+func readCacheData(r *myReader) *Data {
+	if !readBool(r) {
+		return nil
+	}
+	x := &Data{}
+	x.Addr = readUint64(r)
+	x.Data = readBytes(r)
+	x.Fields = readCacheFields(r)
+	x.Edges = readCacheEdges(r)
+	return x
+}
+
+// memStatsFields lists the runtime.MemStats fields recorded in a dump.
+func memStatsFields(m *runtime.MemStats) []*uint64 {
+	return []*uint64{
+		&m.Alloc, &m.TotalAlloc, &m.Sys, &m.Lookups, &m.Mallocs, &m.Frees,
+		&m.HeapAlloc, &m.HeapSys, &m.HeapIdle, &m.HeapInuse, &m.HeapReleased, &m.HeapObjects,
+		&m.StackInuse, &m.StackSys, &m.MSpanInuse, &m.MSpanSys, &m.MCacheInuse, &m.MCacheSys,
+		&m.BuckHashSys, &m.GCSys, &m.OtherSys, &m.NextGC, &m.LastGC, &m.PauseTotalNs,
+	}
+}
+
+func encodeDump(w *cacheWriter, d *Dump) {
+	// params
+	w.putUint64(uint64(d.Version))
+	w.putBool(d.Order == binary.BigEndian)
+	w.putUint64(d.PtrSize)
+	w.putUint64(d.HChanSize)
+	w.putUint64(d.HeapStart)
+	w.putUint64(d.HeapEnd)
+	w.putUint64(uint64(d.TheChar))
+	w.putString(d.Experiment)
+	w.putString(d.Arch)
+	w.putString(d.GoVersion)
+	w.putUint64(d.Ncpu)
+
+	// types
+	typeIndex := map[*Type]int{}
+	w.putUint64(uint64(len(d.Types)))
+	for i, t := range d.Types {
+		typeIndex[t] = i
+		w.putString(t.Name)
+		w.putUint64(t.Size)
+		w.putBool(t.efaceptr)
+		w.putFields(t.Fields)
+		w.putUint64(t.Addr)
+	}
+	w.putUint64(uint64(len(d.ItabMap)))
+	for addr, ptr := range d.ItabMap {
+		w.putUint64(addr)
+		w.putBool(ptr)
+	}
+
+	// full types
+	w.putUint64(uint64(len(d.FTList)))
+	for _, ft := range d.FTList {
+		i, ok := typeIndex[ft.Typ]
+		if !ok {
+			i = -1
+		}
+		w.putIndex(i)
+		w.putUint64(uint64(ft.Kind))
+		w.putUint64(ft.Size)
+		w.putString(ft.Name)
+		if ft.Typ != nil && ft.Kind == TypeKindObject {
+			// shares the type's fields, see nameFullType
+			continue
+		}
+		w.putFields(ft.Fields)
+	}
+
+	// objects
+	n := d.NumObjects()
+	w.putUint64(uint64(n))
+	var b [8]byte
+	for _, a := range d.objects.addr {
+		binary.LittleEndian.PutUint64(b[:], a)
+		w.w.Write(b[:])
+	}
+	for _, off := range d.objects.offset {
+		binary.LittleEndian.PutUint64(b[:], uint64(off))
+		w.w.Write(b[:])
+	}
+	for _, ft := range d.objects.ft {
+		binary.LittleEndian.PutUint32(b[:], ft)
+		w.w.Write(b[:4])
+	}
+
+	// goroutines and stacks
+	frameIndex := map[*StackFrame]int{nil: -1}
+	for i, f := range d.Frames {
+		frameIndex[f] = i
+	}
+	gIndex := map[*GoRoutine]int{nil: -1}
+	for i, g := range d.Goroutines {
+		gIndex[g] = i
+	}
+	w.putUint64(uint64(len(d.Frames)))
+	for _, f := range d.Frames {
+		w.putString(f.Name)
+		w.putIndex(frameIndex[f.Parent])
+		w.putIndex(gIndex[f.Goroutine])
+		w.putUint64(f.Depth)
+		w.putBytes(f.Data)
+		w.putEdges(f.Edges)
+		w.putUint64(f.Addr)
+		w.putUint64(f.childaddr)
+		w.putUint64(f.Entry)
+		w.putUint64(f.PC)
+		w.putFields(f.Fields)
+	}
+	w.putUint64(uint64(len(d.Goroutines)))
+	for _, g := range d.Goroutines {
+		w.putIndex(frameIndex[g.Bos])
+		w.putIndex(int(g.Ctxt))
+		w.putUint64(g.Addr)
+		w.putUint64(g.bosaddr)
+		w.putUint64(g.Goid)
+		w.putUint64(g.Gopc)
+		w.putUint64(g.Status)
+		w.putBool(g.IsSystem)
+		w.putBool(g.IsBackground)
+		w.putUint64(g.WaitSince)
+		w.putString(g.WaitReason)
+		w.putUint64(g.ctxtaddr)
+		w.putUint64(g.maddr)
+		w.putUint64(g.deferaddr)
+		w.putUint64(g.panicaddr)
+	}
+
+	// roots
+	for _, roots := range [][]*OtherRoot{d.Otherroots, d.finalizerRoots} {
+		w.putUint64(uint64(len(roots)))
+		for _, r := range roots {
+			w.putString(r.Description)
+			w.putEdges(r.Edges)
+			w.putUint64(r.toaddr)
+		}
+	}
+	w.putData(d.Data)
+	w.putData(d.Bss)
+	w.putUint64(uint64(len(d.Finalizers)))
+	for _, f := range d.Finalizers {
+		for _, x := range []uint64{f.ObjAddr, f.FnAddr, f.Code, f.FintAddr, f.OtAddr} {
+			w.putUint64(x)
+		}
+		w.putEdges(f.Edges)
+	}
+	w.putUint64(uint64(len(d.QFinal)))
+	for _, f := range d.QFinal {
+		for _, x := range []uint64{f.ObjAddr, f.FnAddr, f.Code, f.FintAddr, f.OtAddr} {
+			w.putUint64(x)
+		}
+		w.putEdges(f.Edges)
+	}
+
+	// everything else
+	w.putUint64(uint64(len(d.Osthreads)))
+	for _, t := range d.Osthreads {
+		for _, x := range []uint64{t.Addr, t.Id, t.ProcId} {
+			w.putUint64(x)
+		}
+	}
+	w.putBool(d.Memstats != nil)
+	if d.Memstats != nil {
+		for _, p := range memStatsFields(d.Memstats) {
+			w.putUint64(*p)
+		}
+		for _, x := range d.Memstats.PauseNs {
+			w.putUint64(x)
+		}
+		w.putUint64(uint64(d.Memstats.NumGC))
+	}
+	w.putUint64(uint64(len(d.Defers)))
+	for _, x := range d.Defers {
+		for _, y := range []uint64{x.Addr, x.gp, x.Argp, x.Pc, x.FnAddr, x.Code, x.link} {
+			w.putUint64(y)
+		}
+	}
+	w.putUint64(uint64(len(d.Panics)))
+	for _, x := range d.Panics {
+		for _, y := range []uint64{x.Addr, x.gp, x.Typ, x.DataAddr, x.defr, x.link} {
+			w.putUint64(y)
+		}
+	}
+	profIndex := map[*MemProfEntry]int{nil: -1}
+	w.putUint64(uint64(len(d.MemProf)))
+	for i, e := range d.MemProf {
+		profIndex[e] = i
+		w.putUint64(e.Addr)
+		w.putUint64(e.Size)
+		w.putUint64(uint64(len(e.Stack)))
+		for _, f := range e.Stack {
+			w.putString(f.Func)
+			w.putString(f.File)
+			w.putUint64(f.Line)
+		}
+		w.putUint64(e.Allocs)
+		w.putUint64(e.Frees)
+	}
+	w.putUint64(uint64(len(d.AllocSamples)))
+	for _, s := range d.AllocSamples {
+		w.putUint64(s.Addr)
+		w.putIndex(profIndex[s.Prof])
+	}
+	w.putUint64(uint64(len(d.mapTypes)))
+	for name, mt := range d.mapTypes {
+		w.putString(name)
+		w.putString(mt.name)
+		w.putBool(mt.swiss)
+		for _, x := range []uint64{mt.count, mt.b, mt.buckets, mt.oldbuckets, mt.dirLen, mt.hdrSize,
+			mt.groups, mt.lengthMask, mt.tableSize,
+			mt.bucketSize, mt.bucketCnt, mt.tophash, mt.keys, mt.values, mt.overflow,
+			mt.keySize, mt.valueSize, mt.slotSize} {
+			w.putUint64(x)
+		}
+		w.putFields(mt.keyFields)
+		w.putFields(mt.valueFields)
+		w.putBool(mt.nested)
+	}
+	w.putUint64(uint64(len(d.elemSizes)))
+	for name, n := range d.elemSizes {
+		w.putString(name)
+		w.putUint64(n)
+	}
+	w.putBool(d.hchan != nil)
+	if l := d.hchan; l != nil {
+		for _, x := range []uint64{l.qcount, l.dataqsiz, l.sendx, l.recvx, l.buf,
+			l.closed, l.closedSize, l.recvq, l.sendq, l.sudogG, l.sudogNext} {
+			w.putUint64(x)
+		}
+	}
+	w.putUint64(uint64(len(d.chanTypes)))
+	for name, ct := range d.chanTypes {
+		w.putString(name)
+		w.putString(ct.name)
+		w.putUint64(ct.elemSize)
+		w.putFields(ct.elemFields)
+	}
+}
+
+// decodeDump reads what encodeDump wrote.  Errors are left in r.
+func decodeDump(r *myReader, d *Dump) {
+	// params
+	d.Version = Version(readUint64(r))
+	if readBool(r) {
+		d.Order = binary.BigEndian
+	} else {
+		d.Order = binary.LittleEndian
+	}
+	d.PtrSize = readUint64(r)
+	d.HChanSize = readUint64(r)
+	d.HeapStart = readUint64(r)
+	d.HeapEnd = readUint64(r)
+	d.TheChar = byte(readUint64(r))
+	d.Experiment = readString(r)
+	d.Arch = readString(r)
+	d.GoVersion = readString(r)
+	d.Ncpu = readUint64(r)
+
+	// types
+	d.TypeMap = map[uint64]*Type{}
+	if n := readCount(r); n > 0 {
+		d.Types = make([]*Type, n)
+	}
+	for i := range d.Types {
+		t := &Type{}
+		t.Name = readString(r)
+		t.Size = readUint64(r)
+		t.efaceptr = readBool(r)
+		t.Fields = readCacheFields(r)
+		t.Addr = readUint64(r)
+		d.Types[i] = t
+		d.TypeMap[t.Addr] = t
+	}
+	d.ItabMap = map[uint64]bool{}
+	for n := readCount(r); n > 0 && r.err == nil; n-- {
+		addr := readUint64(r)
+		d.ItabMap[addr] = readBool(r)
+	}
+
+	// full types
+	if n := readCount(r); n > 0 {
+		d.FTList = make([]*FullType, n)
+	}
+	for i := range d.FTList {
+		ft := &FullType{Id: i}
+		if j := readIndex(r); j >= 0 && j < len(d.Types) {
+			ft.Typ = d.Types[j]
+		}
+		ft.Kind = TypeKind(readUint64(r))
+		ft.Size = readUint64(r)
+		ft.Name = readString(r)
+		if ft.Typ != nil && ft.Kind == TypeKindObject {
+			ft.Fields = ft.Typ.Fields
+		} else {
+			ft.Fields = readCacheFields(r)
+		}
+		d.FTList[i] = ft
+	}
+	if r.err != nil {
+		return
+	}
+
+	// objects
+	n := readCount(r)
+	if n > 0 && r.err == nil {
+		b := make([]byte, 8*n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			r.fail(err)
+			return
+		}
+		d.objects.addr = make([]uint64, n)
+		for i := range d.objects.addr {
+			d.objects.addr[i] = binary.LittleEndian.Uint64(b[8*i:])
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			r.fail(err)
+			return
+		}
+		d.objects.offset = make([]int64, n)
+		for i := range d.objects.offset {
+			d.objects.offset[i] = int64(binary.LittleEndian.Uint64(b[8*i:]))
+		}
+		b = b[:4*n]
+		if _, err := io.ReadFull(r, b); err != nil {
+			r.fail(err)
+			return
+		}
+		d.objects.ft = make([]uint32, n)
+		for i := range d.objects.ft {
+			d.objects.ft[i] = binary.LittleEndian.Uint32(b[4*i:])
+			if int(d.objects.ft[i]) >= len(d.FTList) {
+				r.fail(errors.New("bad full type id"))
+				return
+			}
+		}
+	}
+
+	// goroutines and stacks
+	if n := readCount(r); n > 0 {
+		d.Frames = make([]*StackFrame, n)
+	}
+	for i := range d.Frames {
+		d.Frames[i] = &StackFrame{}
+	}
+	parents := make([]int, len(d.Frames))
+	gs := make([]int, len(d.Frames))
+	for i, f := range d.Frames {
+		f.Name = readString(r)
+		parents[i] = readIndex(r)
+		gs[i] = readIndex(r)
+		f.Depth = readUint64(r)
+		f.Data = readBytes(r)
+		f.Edges = readCacheEdges(r)
+		f.Addr = readUint64(r)
+		f.childaddr = readUint64(r)
+		f.Entry = readUint64(r)
+		f.PC = readUint64(r)
+		f.Fields = readCacheFields(r)
+	}
+	if n := readCount(r); n > 0 {
+		d.Goroutines = make([]*GoRoutine, n)
+	}
+	for i := range d.Goroutines {
+		g := &GoRoutine{}
+		if j := readIndex(r); j >= 0 && j < len(d.Frames) {
+			g.Bos = d.Frames[j]
+		}
+		g.Ctxt = ObjId(readIndex(r))
+		g.Addr = readUint64(r)
+		g.bosaddr = readUint64(r)
+		g.Goid = readUint64(r)
+		g.Gopc = readUint64(r)
+		g.Status = readUint64(r)
+		g.IsSystem = readBool(r)
+		g.IsBackground = readBool(r)
+		g.WaitSince = readUint64(r)
+		g.WaitReason = readString(r)
+		g.ctxtaddr = readUint64(r)
+		g.maddr = readUint64(r)
+		g.deferaddr = readUint64(r)
+		g.panicaddr = readUint64(r)
+		d.Goroutines[i] = g
+	}
+	for i, f := range d.Frames {
+		if j := parents[i]; j >= 0 && j < len(d.Frames) {
+			f.Parent = d.Frames[j]
+		}
+		if j := gs[i]; j >= 0 && j < len(d.Goroutines) {
+			f.Goroutine = d.Goroutines[j]
+		}
+	}
+
+	// roots
+	d.Otherroots = readCacheRoots(r)
+	d.finalizerRoots = readCacheRoots(r)
+	d.Data = readCacheData(r)
+	d.Bss = readCacheData(r)
+	if n := readCount(r); n > 0 {
+		d.Finalizers = make([]*Finalizer, n)
+	}
+	for i := range d.Finalizers {
+		f := &Finalizer{}
+		for _, p := range []*uint64{&f.ObjAddr, &f.FnAddr, &f.Code, &f.FintAddr, &f.OtAddr} {
+			*p = readUint64(r)
+		}
+		f.Edges = readCacheEdges(r)
+		d.Finalizers[i] = f
+	}
+	if n := readCount(r); n > 0 {
+		d.QFinal = make([]*QFinalizer, n)
+	}
+	for i := range d.QFinal {
+		f := &QFinalizer{}
+		for _, p := range []*uint64{&f.ObjAddr, &f.FnAddr, &f.Code, &f.FintAddr, &f.OtAddr} {
+			*p = readUint64(r)
+		}
+		f.Edges = readCacheEdges(r)
+		d.QFinal[i] = f
+	}
+
+	// everything else
+	if n := readCount(r); n > 0 {
+		d.Osthreads = make([]*OSThread, n)
+	}
+	for i := range d.Osthreads {
+		t := &OSThread{}
+		for _, p := range []*uint64{&t.Addr, &t.Id, &t.ProcId} {
+			*p = readUint64(r)
+		}
+		d.Osthreads[i] = t
+	}
+	if readBool(r) {
+		m := &runtime.MemStats{}
+		for _, p := range memStatsFields(m) {
+			*p = readUint64(r)
+		}
+		for i := range m.PauseNs {
+			m.PauseNs[i] = readUint64(r)
+		}
+		m.NumGC = uint32(readUint64(r))
+		d.Memstats = m
+	}
+	if n := readCount(r); n > 0 {
+		d.Defers = make([]*Defer, n)
+	}
+	for i := range d.Defers {
+		x := &Defer{}
+		for _, p := range []*uint64{&x.Addr, &x.gp, &x.Argp, &x.Pc, &x.FnAddr, &x.Code, &x.link} {
+			*p = readUint64(r)
+		}
+		d.Defers[i] = x
+	}
+	if n := readCount(r); n > 0 {
+		d.Panics = make([]*Panic, n)
+	}
+	for i := range d.Panics {
+		x := &Panic{}
+		for _, p := range []*uint64{&x.Addr, &x.gp, &x.Typ, &x.DataAddr, &x.defr, &x.link} {
+			*p = readUint64(r)
+		}
+		d.Panics[i] = x
+	}
+	if n := readCount(r); n > 0 {
+		d.MemProf = make([]*MemProfEntry, n)
+	}
+	for i := range d.MemProf {
+		e := &MemProfEntry{}
+		e.Addr = readUint64(r)
+		e.Size = readUint64(r)
+		if n := readCount(r); n > 0 {
+			e.Stack = make([]MemProfFrame, n)
+		}
+		for j := range e.Stack {
+			e.Stack[j].Func = readString(r)
+			e.Stack[j].File = readString(r)
+			e.Stack[j].Line = readUint64(r)
+		}
+		e.Allocs = readUint64(r)
+		e.Frees = readUint64(r)
+		d.MemProf[i] = e
+	}
+	if n := readCount(r); n > 0 {
+		d.AllocSamples = make([]*AllocSample, n)
+	}
+	for i := range d.AllocSamples {
+		s := &AllocSample{}
+		s.Addr = readUint64(r)
+		if j := readIndex(r); j >= 0 && j < len(d.MemProf) {
+			s.Prof = d.MemProf[j]
+		}
+		d.AllocSamples[i] = s
+	}
+	if n := readCount(r); n > 0 {
+		d.mapTypes = make(map[string]*mapType, n)
+		for ; n > 0 && r.err == nil; n-- {
+			name := readString(r)
+			mt := &mapType{name: readString(r)}
+			mt.swiss = readBool(r)
+			for _, p := range []*uint64{&mt.count, &mt.b, &mt.buckets, &mt.oldbuckets, &mt.dirLen, &mt.hdrSize,
+				&mt.groups, &mt.lengthMask, &mt.tableSize,
+				&mt.bucketSize, &mt.bucketCnt, &mt.tophash, &mt.keys, &mt.values, &mt.overflow,
+				&mt.keySize, &mt.valueSize, &mt.slotSize} {
+				*p = readUint64(r)
+			}
+			mt.keyFields = readCacheFields(r)
+			mt.valueFields = readCacheFields(r)
+			mt.nested = readBool(r)
+			d.mapTypes[name] = mt
+		}
+	}
+	if n := readCount(r); n > 0 {
+		d.elemSizes = make(map[string]uint64, n)
+		for ; n > 0 && r.err == nil; n-- {
+			name := readString(r)
+			d.elemSizes[name] = readUint64(r)
+		}
+	}
+	if readBool(r) {
+		l := &chanLayout{}
+		for _, p := range []*uint64{&l.qcount, &l.dataqsiz, &l.sendx, &l.recvx, &l.buf,
+			&l.closed, &l.closedSize, &l.recvq, &l.sendq, &l.sudogG, &l.sudogNext} {
+			*p = readUint64(r)
+		}
+		d.hchan = l
+	}
+	if n := readCount(r); n > 0 {
+		d.chanTypes = make(map[string]*chanType, n)
+		for ; n > 0 && r.err == nil; n-- {
+			name := readString(r)
+			ct := &chanType{name: readString(r), elemSize: readUint64(r)}
+			ct.elemFields = readCacheFields(r)
+			d.chanTypes[name] = ct
+		}
+	}
+}
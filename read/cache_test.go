@@ -0,0 +1,126 @@
+package read
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	name := writeTestDump(t, "cache.dump")
+	idx := name + ".idx"
+	fresh := openTest(t, name)
+	first := openTest(t, name, Cache(""))
+	if first.edgeOff == nil {
+		t.Fatal("edges not saved")
+	}
+	fi, err := os.Stat(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := openTest(t, name, Cache(""))
+	if fi2, err := os.Stat(idx); err != nil {
+		t.Fatal(err)
+	} else if !os.SameFile(fi, fi2) {
+		t.Fatal("index file rewritten by second load")
+	}
+	if second.edgeOff == nil {
+		t.Fatal("edges not loaded")
+	}
+	compareDumps(t, fresh, first)
+	compareDumps(t, fresh, second)
+}
+
+func TestCacheStore(t *testing.T) {
+	name := writeTestDump(t, "store.dump")
+	idx := name + ".idx"
+	d := openTest(t, name, Cache(""))
+	size := func() int64 {
+		fi, err := os.Stat(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	if err := d.CacheStore("test", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	n := size()
+	for i := 0; i < 3; i++ {
+		if err := d.CacheStore("test", []byte("again")); err != nil {
+			t.Fatal(err)
+		}
+		if size() != n {
+			t.Fatalf("index file grew from %d to %d bytes storing the same section", n, size())
+		}
+	}
+	if err := d.CacheStore("other", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CacheStore("dump", nil); err == nil {
+		t.Error("stored a section named dump")
+	}
+
+	e := openTest(t, name, Cache(""))
+	for sec, want := range map[string]string{"test": "again", "other": "other"} {
+		if b, ok := e.CacheLoad(sec); !ok || !bytes.Equal(b, []byte(want)) {
+			t.Errorf("section %s is %q, %v, want %q", sec, b, ok, want)
+		}
+	}
+	compareDumps(t, openTest(t, name), e)
+}
+
+// TestCacheStale checks that changing the size or the modification
+// time of the dump makes its index file out of date.
+func TestCacheStale(t *testing.T) {
+	name := writeTestDump(t, "stale.dump")
+	idx := name + ".idx"
+	openTest(t, name, Cache(""))
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, want error) {
+		t.Helper()
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stamp, err := makeStamp(fi, testExec, newConfig(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := readCache(idx, stamp, f, 1); err != want {
+			t.Errorf("%s: loading index file returned %v, want %v", what, err, want)
+		}
+	}
+	check("unchanged", nil)
+
+	mtime := fi.ModTime()
+	if err := os.Chtimes(name, mtime, mtime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	check("new mtime", errStaleCache)
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte{0})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	check("new size", errStaleCache)
+}
@@ -0,0 +1,316 @@
+package read
+
+import (
+	"debug/dwarf"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Chan is a Go channel, decoded from its header.
+type Chan struct {
+	Type       string  // chan T
+	ElemSize   uint64  // size of T
+	ElemFields []Field // layout of T
+	Len, Cap   uint64  // elements in the buffer, and its size
+	Buf        uint64  // address of the buffer
+	Closed     bool
+	Elems      []ChanElem   // buffered elements, next to be received first
+	RecvWait   []*GoRoutine // goroutines blocked receiving, in queue order
+	SendWait   []*GoRoutine // goroutines blocked sending, in queue order
+}
+
+// A ChanElem is one element in the buffer of a channel.  The edges
+// are those found in the element, with offsets relative to it.
+type ChanElem struct {
+	Data  []byte
+	Edges []Edge
+}
+
+// A chanLayout describes the header of a channel, as found in the
+// Dwarf info of runtime.hchan, and the runtime's wait queue entries.
+// Absent fields are ^0.
+type chanLayout struct {
+	qcount, dataqsiz, sendx, recvx uint64
+	buf                            uint64 // ^0 if the buffer follows the header
+	closed, closedSize             uint64
+	recvq, sendq                   uint64 // waitq{first, last *sudog}
+	sudogG, sudogNext              uint64
+}
+
+const noField = ^uint64(0)
+
+// go13Chans are the channel layouts of go1.3, by pointer size.  Its
+// runtime was written in C and left no Dwarf info for channels, so
+// these need to be kept in sync with src/pkg/runtime/chan.h in the
+// go1.3 distribution.
+var go13Chans = map[uint64]*chanLayout{
+	4: {qcount: 0, dataqsiz: 4, closed: 12, closedSize: 1, sendx: 20, recvx: 24,
+		buf: noField, recvq: noField, sendq: noField, sudogG: noField, sudogNext: noField},
+	8: {qcount: 0, dataqsiz: 8, closed: 20, closedSize: 1, sendx: 32, recvx: 40,
+		buf: noField, recvq: noField, sendq: noField, sudogG: noField, sudogNext: noField},
+}
+
+// A chanType describes the elements of a channel type.
+type chanType struct {
+	name       string // chan T
+	elemSize   uint64
+	elemFields []Field
+}
+
+// chanLayout returns the layout of channel headers in d, or nil
+// if it is unknown.
+func (d *Dump) chanLayout() *chanLayout {
+	if d.hchan != nil {
+		return d.hchan
+	}
+	return go13Chans[d.PtrSize]
+}
+
+// findChanTypes finds the channel header layout and the channel
+// types in the Dwarf info.  The compiler describes a chan T as a
+// pointer to a struct hchan<T> laid out like runtime.hchan.
+func findChanTypes(d *Dump, t map[dwarf.Offset]dwarfType) (*chanLayout, map[string]*chanType) {
+	byName := map[string]dwarfType{}
+	structs := map[string]*dwarfStructType{} // not the typedefs of the same name
+	for _, x := range t {
+		byName[x.Name()] = x
+		if s, ok := x.(*dwarfStructType); ok {
+			structs[x.Name()] = s
+		}
+	}
+	hchan, sudog := structs["runtime.hchan"], structs["runtime.sudog"]
+	if hchan == nil {
+		return nil, nil
+	}
+	l := &chanLayout{}
+	for _, m := range []struct {
+		name string
+		off  *uint64
+	}{
+		{"qcount", &l.qcount},
+		{"dataqsiz", &l.dataqsiz},
+		{"sendx", &l.sendx},
+		{"recvx", &l.recvx},
+		{"buf", &l.buf},
+		{"closed", &l.closed},
+		{"recvq", &l.recvq},
+		{"sendq", &l.sendq},
+	} {
+		f := member(hchan, m.name)
+		if f == nil || f.offset+d.PtrSize > hchan.Size() {
+			return nil, nil
+		}
+		*m.off = f.offset
+		if m.name == "closed" {
+			l.closedSize = f.type_.Size()
+		}
+	}
+	l.sudogG, l.sudogNext = noField, noField
+	if sudog != nil {
+		if g, next := member(sudog, "g"), member(sudog, "next"); g != nil && next != nil {
+			l.sudogG, l.sudogNext = g.offset, next.offset
+		}
+	}
+
+	types := map[string]*chanType{}
+	for name := range structs {
+		if !strings.HasPrefix(name, "hchan<") || !strings.HasSuffix(name, ">") {
+			continue
+		}
+		elem := name[len("hchan<") : len(name)-1]
+		et := byName[elem]
+		if et == nil {
+			continue
+		}
+		types[name] = &chanType{
+			name:       "chan " + elem,
+			elemSize:   et.Size(),
+			elemFields: et.Fields(),
+		}
+	}
+	return l, types
+}
+
+// IsChan reports whether object x is a channel that Chan can decode.
+func (d *Dump) IsChan(x ObjId) bool {
+	d.chanOnce.Do(d.findChans)
+	return d.chanObjs[x] != nil
+}
+
+// Chan decodes the channel x.  It returns nil if x is not known to be
+// a channel.  Dumps from go1.4 on record no types for objects, so
+// there a channel is only recognized when a global variable, a local
+// variable or an object of known type refers to it, and only if the
+// dump was read with its executable.  Blocked goroutines are found
+// only with the executable, too.  It is safe to call Chan from
+// multiple goroutines.
+func (d *Dump) Chan(x ObjId) (*Chan, error) {
+	d.chanOnce.Do(d.findChans)
+	ct := d.chanObjs[x]
+	if ct == nil {
+		return nil, nil
+	}
+	c, err := d.readChan(x, ct)
+	if err != nil {
+		return nil, &ObjectError{x, d.Addr(x), err}
+	}
+	return c, nil
+}
+
+// findChans finds the channels in the heap, by their type or by the
+// type of the variables and objects that point to them.
+func (d *Dump) findChans() {
+	d.chanObjs = map[ObjId]*chanType{}
+	if d.chanLayout() == nil {
+		return
+	}
+	scan := func(data []byte, fields []Field) {
+		for _, f := range fields {
+			if f.Kind != FieldKindPtr || f.Offset+d.PtrSize > uint64(len(data)) {
+				continue
+			}
+			ct := d.chanTypes[f.BaseType]
+			if ct == nil {
+				continue
+			}
+			p := readPtr(d, data[f.Offset:])
+			if x := d.FindObj(p); x != ObjNil && d.Addr(x) == p {
+				d.chanObjs[x] = ct
+			}
+		}
+	}
+
+	// Objects typed in the dump itself.
+	types := make([]*chanType, len(d.FTList))
+	pointsToChan := make([]bool, len(d.FTList))
+	for i, ft := range d.FTList {
+		if ft.Kind == TypeKindChan && ft.Typ != nil {
+			types[i] = &chanType{"chan " + ft.Typ.Name, ft.Typ.Size, ft.Typ.Fields}
+		}
+		for _, f := range ft.Fields {
+			if f.Kind == FieldKindPtr && d.chanTypes[f.BaseType] != nil {
+				pointsToChan[i] = true
+			}
+		}
+	}
+	var b []byte
+	for i, n := 0, d.NumObjects(); i < n; i++ {
+		x := ObjId(i)
+		id := d.Ft(x).Id
+		if ct := types[id]; ct != nil {
+			d.chanObjs[x] = ct
+		}
+		if pointsToChan[id] {
+			var err error
+			b, err = d.ContentsInto(x, b)
+			if err == nil {
+				scan(b, d.Ft(x).Fields)
+			}
+		}
+	}
+
+	// Variables.
+	for _, x := range []*Data{d.Data, d.Bss} {
+		if x != nil {
+			scan(x.Data, x.Fields)
+		}
+	}
+	for _, f := range d.Frames {
+		scan(f.Data, f.Fields)
+	}
+}
+
+func (d *Dump) readChan(x ObjId, ct *chanType) (*Chan, error) {
+	l := d.chanLayout()
+	hdr, err := d.ContentsInto(x, nil)
+	if err != nil {
+		return nil, err
+	}
+	word := func(off uint64) uint64 {
+		if off == noField || off+d.PtrSize > uint64(len(hdr)) {
+			return 0
+		}
+		return readPtr(d, hdr[off:])
+	}
+	if l.recvx+d.PtrSize > uint64(len(hdr)) || l.closed+l.closedSize > uint64(len(hdr)) {
+		return nil, fmt.Errorf("channel header is %d bytes, too short", len(hdr))
+	}
+	c := &Chan{
+		Type:       ct.name,
+		ElemSize:   ct.elemSize,
+		ElemFields: ct.elemFields,
+		Len:        word(l.qcount),
+		Cap:        word(l.dataqsiz),
+	}
+	for _, b := range hdr[l.closed : l.closed+l.closedSize] {
+		if b != 0 {
+			c.Closed = true
+		}
+	}
+	if c.Len > c.Cap {
+		return nil, fmt.Errorf("channel has %d elements but room for %d", c.Len, c.Cap)
+	}
+
+	// The buffer is a ring, with the next element to receive at recvx.
+	buf := d.Addr(x) + d.HChanSize
+	if l.buf != noField {
+		buf = word(l.buf)
+	}
+	c.Buf = buf
+	recvx := word(l.recvx)
+	if c.Len > 0 && (ct.elemSize == 0 || recvx >= c.Cap) {
+		return nil, fmt.Errorf("bad channel receive index %d", recvx)
+	}
+	for i := uint64(0); i < c.Len && ct.elemSize > 0; i++ {
+		addr := buf + (recvx+i)%c.Cap*ct.elemSize
+		e, ok := d.readMem(addr, ct.elemSize)
+		if !ok || uint64(len(e)) < ct.elemSize {
+			return nil, fmt.Errorf("channel element %x is not in the heap", addr)
+		}
+		c.Elems = append(c.Elems, ChanElem{e, d.appendFields(nil, e, ct.elemFields)})
+	}
+
+	if l.recvq != noField && l.sudogG != noField {
+		if c.RecvWait, err = d.waitQueue(word(l.recvq), l); err != nil {
+			return nil, err
+		}
+		if c.SendWait, err = d.waitQueue(word(l.sendq), l); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// waitQueue returns the goroutines in the list of sudogs starting
+// at addr.
+func (d *Dump) waitQueue(addr uint64, l *chanLayout) ([]*GoRoutine, error) {
+	var gs []*GoRoutine
+	seen := map[uint64]bool{}
+	for addr != 0 {
+		if seen[addr] {
+			return nil, errors.New("cycle in channel wait queue")
+		}
+		seen[addr] = true
+		g, ok1 := d.readMem(addr+l.sudogG, d.PtrSize)
+		next, ok2 := d.readMem(addr+l.sudogNext, d.PtrSize)
+		if !ok1 || !ok2 || uint64(len(g)) < d.PtrSize || uint64(len(next)) < d.PtrSize {
+			return nil, fmt.Errorf("channel waiter %x is not in the heap", addr)
+		}
+		if gr := d.goroutineAt(readPtr(d, g)); gr != nil {
+			gs = append(gs, gr)
+		}
+		addr = readPtr(d, next)
+	}
+	return gs, nil
+}
+
+// goroutineAt returns the goroutine whose runtime g is at addr, or nil.
+func (d *Dump) goroutineAt(addr uint64) *GoRoutine {
+	for _, g := range d.Goroutines {
+		if g.Addr == addr {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package read
+
+import "testing"
+
+// testChan returns the channel in the global variable name.
+func testChan(t *testing.T, d *Dump, name string) *Chan {
+	t.Helper()
+	f, data := global(t, d, name)
+	x := d.FindObj(readPtr(d, data[f.Offset:]))
+	if x == ObjNil {
+		t.Fatalf("%s is not in the heap", name)
+	}
+	if !d.IsChan(x) {
+		t.Skipf("channels of %s dumps are not understood", d.GoVersion)
+	}
+	c, err := d.Chan(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestChanElems(t *testing.T) {
+	d := openTest(t, testDump(t))
+	c := testChan(t, d, "main.c")
+	if c.Type != "chan string" || c.Len != 2 || c.Cap != 4 || c.Closed {
+		t.Fatalf("main.c is a %s of %d/%d elements, closed %v, want an open chan string of 2/4",
+			c.Type, c.Len, c.Cap, c.Closed)
+	}
+	want := []string{"first", "second"}
+	if len(c.Elems) != len(want) {
+		t.Fatalf("main.c has %d elements decoded, want %d", len(c.Elems), len(want))
+	}
+	for i, e := range c.Elems {
+		s, ok := d.StringValue(e.Data, c.ElemFields[0])
+		if !ok || s != want[i] {
+			t.Errorf("element %d of main.c is %q, %v, want %q", i, s, ok, want[i])
+		}
+	}
+	if len(c.RecvWait) != 0 || len(c.SendWait) != 0 {
+		t.Errorf("main.c has %d receivers and %d senders waiting, want none", len(c.RecvWait), len(c.SendWait))
+	}
+}
+
+func TestChanWaiters(t *testing.T) {
+	d := openTest(t, testDump(t))
+	c := testChan(t, d, "main.blocked")
+	if c.Type != "chan int" || c.Len != 0 || c.Cap != 0 {
+		t.Fatalf("main.blocked is a %s of %d/%d elements, want an unbuffered chan int", c.Type, c.Len, c.Cap)
+	}
+	if len(c.RecvWait) != 3 || len(c.SendWait) != 0 {
+		t.Fatalf("main.blocked has %d receivers and %d senders waiting, want 3 and none", len(c.RecvWait), len(c.SendWait))
+	}
+	seen := map[*GoRoutine]bool{}
+	for _, g := range c.RecvWait {
+		if g == nil || seen[g] {
+			t.Fatalf("receivers %v are not 3 distinct goroutines", c.RecvWait)
+		}
+		seen[g] = true
+		if g.WaitReason != "chan receive" {
+			t.Errorf("goroutine %d waits for %q, want chan receive", g.Goid, g.WaitReason)
+		}
+	}
+}
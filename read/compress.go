@@ -0,0 +1,37 @@
+package read
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// decompress sniffs the start of r for a gzip or bzip2 header.  It
+// returns a reader of the uncompressed dump and whether r was
+// compressed.  Either way the returned reader starts at the
+// beginning of the data read from r.
+func decompress(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, false, err
+		}
+		return zr, true, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(br), true, nil
+	}
+	return br, false, nil
+}
@@ -0,0 +1,67 @@
+package read
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestGzipDump(t *testing.T) {
+	name := testDump(t)
+	in, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	gz := name + ".gz"
+	out, err := os.Create(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCompressed(t, name, gz)
+}
+
+func TestBzip2Dump(t *testing.T) {
+	// There is no bzip2 compressor in the standard library.
+	bz, err := exec.LookPath("bzip2")
+	if err != nil {
+		t.Skip("no bzip2 command")
+	}
+	name := testDump(t)
+	out, err := exec.Command(bz, "-k", "-f", name).CombinedOutput()
+	if err != nil {
+		t.Fatalf("bzip2: %v\n%s", err, out)
+	}
+	testCompressed(t, name, name+".bz2")
+}
+
+// testCompressed checks that the compressed copy of dump loads the
+// same as dump, by name and from a reader.
+func testCompressed(t *testing.T, dump, compressed string) {
+	plain := openTest(t, dump)
+	compareDumps(t, plain, openTest(t, compressed))
+	f, err := os.Open(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := OpenReader(f, testExec, Logger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	compareDumps(t, plain, d)
+}
@@ -0,0 +1,71 @@
+package read
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBadHeader is returned when a file does not start with a
+// heap dump header of a version we understand.
+var ErrBadHeader = errors.New("not a heap dump file")
+
+// A FormatError reports a record in a heap dump file that
+// could not be decoded.
+type FormatError struct {
+	Offset int64  // position of the start of the record in the dump file
+	Tag    uint64 // kind of the record
+	Err    error  // what went wrong
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("heap dump: %s record at offset %d: %v", tagName(e.Tag), e.Offset, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// An ObjectError reports a heap object whose contents or
+// outgoing edges could not be read.
+type ObjectError struct {
+	Obj  ObjId  // id of the object
+	Addr uint64 // address of the object
+	Err  error  // what went wrong
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("heap dump: object %x: %v", e.Addr, e.Err)
+}
+
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+var tagNames = []string{
+	tagEOF:         "eof",
+	tagObject:      "object",
+	tagOtherRoot:   "other root",
+	tagType:        "type",
+	tagGoRoutine:   "goroutine",
+	tagStackFrame:  "stack frame",
+	tagParams:      "params",
+	tagFinalizer:   "finalizer",
+	tagItab:        "itab",
+	tagOSThread:    "os thread",
+	tagMemStats:    "memstats",
+	tagQFinal:      "queued finalizer",
+	tagData:        "data",
+	tagBss:         "bss",
+	tagDefer:       "defer",
+	tagPanic:       "panic",
+	tagMemProf:     "memprof",
+	tagAllocSample: "alloc sample",
+}
+
+// tagName returns a human-readable name for a record kind.
+func tagName(tag uint64) string {
+	if tag < uint64(len(tagNames)) {
+		return tagNames[tag]
+	}
+	return fmt.Sprintf("unknown(%d)", tag)
+}
@@ -0,0 +1,56 @@
+package read
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// openBytes loads a dump held in memory.
+func openBytes(data []byte, opts ...Option) (*Dump, error) {
+	return OpenReaderAt(bytes.NewReader(data), int64(len(data)), "", append([]Option{Logger(nil)}, opts...)...)
+}
+
+func TestTruncatedDump(t *testing.T) {
+	data, err := ioutil.ReadFile(testDump(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := bytes.IndexByte(data, '\n') + 1
+	for _, workers := range []int{1, 4} {
+		for _, n := range []int{hdr + 1, len(data) / 4, len(data) / 2, len(data) - 1} {
+			_, err := openBytes(data[:n], Parallelism(workers))
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Errorf("dump truncated to %d bytes, %d workers: got %v, want a *FormatError", n, workers, err)
+				continue
+			}
+			if fe.Offset < int64(hdr) || fe.Offset > int64(n) {
+				t.Errorf("dump truncated to %d bytes, %d workers: error at offset %d", n, workers, fe.Offset)
+			}
+		}
+	}
+}
+
+func TestMalformedDump(t *testing.T) {
+	data, err := ioutil.ReadFile(testDump(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := bytes.IndexByte(data, '\n') + 1
+
+	bad := append([]byte("go1.99 heap dump\n"), data[hdr:]...)
+	if _, err := openBytes(bad); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("bad header: got %v, want %v", err, ErrBadHeader)
+	}
+
+	// Replace the first record, the parameters, by an unknown kind.
+	bad = append([]byte(nil), data...)
+	bad[hdr] = 0x7f
+	_, err = openBytes(bad)
+	var fe *FormatError
+	if !errors.As(err, &fe) || fe.Offset != int64(hdr) || fe.Tag != 0x7f {
+		t.Errorf("unknown record: got %v, want a *FormatError for kind 127 at offset %d", err, hdr)
+	}
+}
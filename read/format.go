@@ -0,0 +1,292 @@
+package read
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// A Version identifies the layout of the records in a heap dump,
+// as announced by the header line of the dump file.
+type Version int
+
+const (
+	Go13 Version = 13 // "go1.3 heap dump"
+	Go14 Version = 14 // "go1.4 heap dump"
+	Go17 Version = 17 // "go1.7 heap dump", used by all later releases
+)
+
+func (v Version) String() string {
+	return fmt.Sprintf("go1.%d", int(v)%10)
+}
+
+// A format knows how to decode the records whose layout
+// differs between heap dump versions.  All other records are
+// decoded the same way for every version.
+type format struct {
+	version Version
+	header  string
+
+	// map from field kind in the dump to our FieldKind
+	fieldKinds []FieldKind
+
+	readObject func(r *myReader, f *format) ObjectRecord
+	skipObject func(r *myReader, f *format) // readObject without the decoding
+	readType   func(r *myReader, f *format) *Type
+	readParams func(r *myReader, p *Params)
+	readItab   func(r *myReader, types map[uint64]*Type) (addr uint64, ptr bool)
+}
+
+var formats = []*format{
+	{
+		version: Go13,
+		header:  "go1.3 heap dump",
+		fieldKinds: []FieldKind{
+			FieldKindEol,
+			FieldKindPtr,
+			FieldKindString,
+			FieldKindSlice,
+			FieldKindIface,
+			FieldKindEface,
+		},
+		readObject: readObject13,
+		skipObject: skipObject13,
+		readType:   readType13,
+		readParams: readParams13,
+		readItab:   readItab13,
+	},
+	{
+		version: Go14,
+		header:  "go1.4 heap dump",
+		fieldKinds: []FieldKind{
+			FieldKindEol,
+			FieldKindPtr,
+			FieldKindIface,
+			FieldKindEface,
+		},
+		readObject: readObject14,
+		skipObject: skipObject14,
+		readType:   readType14,
+		readParams: readParams14,
+		readItab:   readItab14,
+	},
+	{
+		version: Go17,
+		header:  "go1.7 heap dump",
+		fieldKinds: []FieldKind{
+			FieldKindEol,
+			FieldKindPtr,
+			FieldKindIface,
+			FieldKindEface,
+		},
+		readObject: readObject14,
+		skipObject: skipObject14,
+		readType:   readType14,
+		readParams: readParams17,
+		readItab:   readItab14,
+	},
+}
+
+// findFormat returns the format with the given header line, or nil.
+func findFormat(hdr string) *format {
+	for _, f := range formats {
+		if f.header == hdr {
+			return f
+		}
+	}
+	return nil
+}
+
+// go1.3 objects carry their type, and a size followed by contents.
+func readObject13(r *myReader, f *format) ObjectRecord {
+	var o ObjectRecord
+	o.Addr = readUint64(r)
+	o.TypeAddr = readUint64(r)
+	o.Kind = TypeKind(readUint64(r))
+	o.Size = readUint64(r)
+	o.Offset = r.Count()
+	r.Skip(int64(o.Size))
+	return o
+}
+
+// go1.4 and later objects have no type, just contents followed
+// by a list of the pointer fields in the object.
+func readObject14(r *myReader, f *format) ObjectRecord {
+	var o ObjectRecord
+	o.Addr = readUint64(r)
+	o.Kind = TypeKindObject
+	o.Size = readUint64(r)
+	o.Offset = r.Count()
+	r.Skip(int64(o.Size))
+	o.Fields = f.readFields(r)
+	return o
+}
+
+// skipObject13 and skipObject14 read past an object record, for the
+// pre-scan of parallel loading.  They fail where readObject would.
+func skipObject13(r *myReader, f *format) {
+	readUint64(r) // address
+	readUint64(r) // type
+	readUint64(r) // kind
+	r.Skip(int64(readUint64(r)))
+}
+
+func skipObject14(r *myReader, f *format) {
+	readUint64(r) // address
+	r.Skip(int64(readUint64(r)))
+	f.skipFields(r)
+}
+
+func readType13(r *myReader, f *format) *Type {
+	typ := &Type{}
+	typ.Addr = readUint64(r)
+	typ.Size = readUint64(r)
+	typ.Name = readString(r)
+	typ.efaceptr = readBool(r)
+	typ.Fields = f.readFields(r)
+	return typ
+}
+
+// go1.4 and later types have no field list.
+func readType14(r *myReader, f *format) *Type {
+	typ := &Type{}
+	typ.Addr = readUint64(r)
+	typ.Size = readUint64(r)
+	typ.Name = readString(r)
+	typ.efaceptr = readBool(r)
+	return typ
+}
+
+func readOrder(r *myReader, p *Params) {
+	if readUint64(r) == 0 {
+		p.Order = binary.LittleEndian
+	} else {
+		p.Order = binary.BigEndian
+	}
+	p.PtrSize = readUint64(r)
+	if r.err == nil && p.PtrSize != 4 && p.PtrSize != 8 {
+		r.fail(fmt.Errorf("unsupported pointer size %d", p.PtrSize))
+	}
+}
+
+func readParams13(r *myReader, p *Params) {
+	readOrder(r, p)
+	p.HChanSize = readUint64(r)
+	p.HeapStart = readUint64(r)
+	p.HeapEnd = readUint64(r)
+	p.TheChar = byte(readUint64(r))
+	p.Experiment = readString(r)
+	p.Ncpu = readUint64(r)
+}
+
+// go1.4 dropped the channel header size.
+func readParams14(r *myReader, p *Params) {
+	readOrder(r, p)
+	p.HeapStart = readUint64(r)
+	p.HeapEnd = readUint64(r)
+	p.TheChar = byte(readUint64(r))
+	p.Experiment = readString(r)
+	p.Ncpu = readUint64(r)
+}
+
+// go1.7 replaced the arch character with GOARCH.  The string after
+// it is GOEXPERIMENT, usually empty, up to go1.16, and the runtime's
+// build version since go1.17.  Both use the same format version, so
+// which one a dump holds can't be told from its header.
+func readParams17(r *myReader, p *Params) {
+	readOrder(r, p)
+	p.HeapStart = readUint64(r)
+	p.HeapEnd = readUint64(r)
+	p.Arch = readString(r)
+	p.GoVersion = readString(r)
+	p.Ncpu = readUint64(r)
+}
+
+func readItab13(r *myReader, types map[uint64]*Type) (uint64, bool) {
+	addr := readUint64(r)
+	ptr := readBool(r)
+	return addr, ptr
+}
+
+// go1.4 and later itabs refer to the type record of the
+// dynamic type, which always precedes them.
+func readItab14(r *myReader, types map[uint64]*Type) (uint64, bool) {
+	addr := readUint64(r)
+	typaddr := readUint64(r)
+	if r.err != nil {
+		return 0, false
+	}
+	t := types[typaddr]
+	if t == nil {
+		r.fail(fmt.Errorf("itab %x refers to unknown type %x", addr, typaddr))
+		return 0, false
+	}
+	return addr, t.efaceptr
+}
+
+// readFields reads a field list, translating field kinds
+// from their encoding in this format.
+func (f *format) readFields(r *myReader) []Field {
+	var x []Field
+	for {
+		k := readUint64(r)
+		if k >= uint64(len(f.fieldKinds)) {
+			r.fail(fmt.Errorf("unknown field kind %d", k))
+			return x
+		}
+		kind := f.fieldKinds[k]
+		if kind == FieldKindEol {
+			// TODO: sort by offset, or check that it is sorted
+			return x
+		}
+		x = append(x, Field{Kind: kind, Offset: readUint64(r)})
+	}
+}
+
+// skipFields reads past a field list.
+func (f *format) skipFields(r *myReader) {
+	for {
+		k := readUint64(r)
+		if k >= uint64(len(f.fieldKinds)) {
+			r.fail(fmt.Errorf("unknown field kind %d", k))
+			return
+		}
+		if f.fieldKinds[k] == FieldKindEol {
+			return
+		}
+		readUint64(r) // offset
+	}
+}
+
+// layoutKey returns a string uniquely describing a field list,
+// suitable for use as a map key.
+func layoutKey(fields []Field) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	b := make([]byte, 0, 3*len(fields))
+	for _, f := range fields {
+		b = binary.AppendUvarint(b, uint64(f.Kind))
+		b = binary.AppendUvarint(b, f.Offset)
+	}
+	return string(b)
+}
+
+// layoutName returns a name for an object of the given size whose
+// only type information is its list of pointer fields.
+func layoutName(size uint64, fields []Field) string {
+	if len(fields) == 0 {
+		return fmt.Sprintf("noptr%d", size)
+	}
+	if len(fields) > 8 {
+		return fmt.Sprintf("obj%d{%d ptrs %08x}", size, len(fields), crc32.ChecksumIEEE([]byte(layoutKey(fields))))
+	}
+	s := fmt.Sprintf("obj%d{", size)
+	for i, f := range fields {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%d", f.Offset)
+	}
+	return s + "}"
+}
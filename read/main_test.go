@@ -0,0 +1,177 @@
+package read
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The tests read heap dumps written by testdata/dumper.go, which
+// TestMain builds into testExec.
+var (
+	testDir  string // removed when the tests are done
+	testExec string // "" if it couldn't be built
+	buildErr error
+
+	testDumpOnce sync.Once
+	testDumpName string
+	testLiteral  uint64 // address of a string literal in the dump
+	testDumpErr  error
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "hprof-test")
+	if err != nil {
+		panic(err)
+	}
+	testDir = dir
+	if gocmd, err := exec.LookPath("go"); err != nil {
+		buildErr = err
+	} else {
+		exe := filepath.Join(dir, "dumper")
+		out, err := exec.Command(gocmd, "build", "-o", exe, filepath.Join("testdata", "dumper.go")).CombinedOutput()
+		if err != nil {
+			buildErr = fmt.Errorf("building dumper: %v\n%s", err, out)
+		} else {
+			testExec = exe
+		}
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runDumper runs the dumper to write a heap dump to name, and
+// returns the address of the string literal it printed.
+func runDumper(name string) (uint64, error) {
+	out, err := exec.Command(testExec, name).Output()
+	if err != nil {
+		return 0, fmt.Errorf("dumper: %v", err)
+	}
+	lit, err := strconv.ParseUint(strings.TrimSpace(string(out)), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("dumper output %q: %v", out, err)
+	}
+	return lit, nil
+}
+
+// writeTestDump writes a new heap dump named name in testDir and
+// returns its path.
+func writeTestDump(t *testing.T, name string) string {
+	t.Helper()
+	if testExec == "" {
+		t.Skipf("no dumper: %v", buildErr)
+	}
+	name = filepath.Join(testDir, name)
+	if _, err := runDumper(name); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// testDump returns the name of a heap dump shared by the tests that
+// don't modify it.
+func testDump(t *testing.T) string {
+	t.Helper()
+	if testExec == "" {
+		t.Skipf("no dumper: %v", buildErr)
+	}
+	testDumpOnce.Do(func() {
+		testDumpName = filepath.Join(testDir, "shared.dump")
+		testLiteral, testDumpErr = runDumper(testDumpName)
+	})
+	if testDumpErr != nil {
+		t.Fatal(testDumpErr)
+	}
+	return testDumpName
+}
+
+// openTest opens dump with the dumper as its executable, discarding
+// warnings, and closes it when the test is done.
+func openTest(t *testing.T, dump string, opts ...Option) *Dump {
+	t.Helper()
+	d, err := Open(dump, testExec, append([]Option{Logger(nil)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// global returns the field of the global variable name and the
+// data segment holding it.
+func global(t *testing.T, d *Dump, name string) (Field, []byte) {
+	t.Helper()
+	for _, x := range []*Data{d.Data, d.Bss} {
+		if x == nil {
+			continue
+		}
+		for _, f := range x.Fields {
+			if f.Name == name {
+				return f, x.Data
+			}
+		}
+	}
+	t.Fatalf("no global %s", name)
+	return Field{}, nil
+}
+
+// compareDumps reports the differences between two loads of the
+// same dump: their objects, full types, edges and roots.
+func compareDumps(t *testing.T, a, b *Dump) {
+	t.Helper()
+	if !reflect.DeepEqual(a.Params, b.Params) {
+		t.Errorf("params %+v, want %+v", b.Params, a.Params)
+	}
+	if len(a.Types) != len(b.Types) {
+		t.Errorf("%d types, want %d", len(b.Types), len(a.Types))
+	}
+	if len(a.FTList) != len(b.FTList) {
+		t.Fatalf("%d full types, want %d", len(b.FTList), len(a.FTList))
+	}
+	for i, x := range a.FTList {
+		y := b.FTList[i]
+		if x.Id != y.Id || x.Name != y.Name || x.Kind != y.Kind || x.Size != y.Size ||
+			(x.Typ == nil) != (y.Typ == nil) || !reflect.DeepEqual(x.Fields, y.Fields) {
+			t.Fatalf("full type %d is %+v, want %+v", i, *y, *x)
+		}
+	}
+	if a.NumObjects() != b.NumObjects() {
+		t.Fatalf("%d objects, want %d", b.NumObjects(), a.NumObjects())
+	}
+	var ea, eb []Edge
+	for i := 0; i < a.NumObjects(); i++ {
+		x := ObjId(i)
+		if a.Addr(x) != b.Addr(x) || a.objects.offset[x] != b.objects.offset[x] || a.Ft(x).Id != b.Ft(x).Id {
+			t.Fatalf("object %d is %x at %d of type %d, want %x at %d of type %d", i,
+				b.Addr(x), b.objects.offset[x], b.Ft(x).Id, a.Addr(x), a.objects.offset[x], a.Ft(x).Id)
+		}
+		var err error
+		if ea, err = a.EdgesInto(x, ea[:0]); err != nil {
+			t.Fatal(err)
+		}
+		if eb, err = b.EdgesInto(x, eb[:0]); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ea, eb) {
+			t.Fatalf("object %x has edges %v, want %v", a.Addr(x), eb, ea)
+		}
+	}
+	ra, rb := a.Roots(), b.Roots()
+	if len(ra) != len(rb) {
+		t.Fatalf("%d roots, want %d", len(rb), len(ra))
+	}
+	for i := range ra {
+		if ra[i].Kind != rb[i].Kind || ra[i].Description != rb[i].Description || !reflect.DeepEqual(ra[i].Edges, rb[i].Edges) {
+			t.Fatalf("root %d is %s %q %v, want %s %q %v", i,
+				rb[i].Kind, rb[i].Description, rb[i].Edges, ra[i].Kind, ra[i].Description, ra[i].Edges)
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package read
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A Map is a Go map, decoded from its header and buckets.  From go1.24
+// on maps are Swiss tables, whose buckets are groups of slots held by
+// one or more tables; they have no overflow or old buckets.
+type Map struct {
+	Type        string  // map[K]V
+	Count       int     // number of entries, according to the header
+	B           uint8   // log2 of the number of buckets, or 0 for a Swiss table
+	Swiss       bool    // the map is a Swiss table
+	Buckets     int     // buckets walked, including overflow and old buckets
+	BucketBytes uint64  // total size of those buckets
+	Slots       int     // key/value slots in those buckets
+	KeyFields   []Field // layout of a key
+	ValueFields []Field // layout of a value
+	Entries     []MapEntry
+}
+
+// A MapEntry is one key/value pair of a map.  The edges are those
+// found in the key and value, with offsets relative to them.
+type MapEntry struct {
+	Key, Value           []byte
+	KeyEdges, ValueEdges []Edge
+}
+
+// LoadFactor returns the average number of entries per bucket,
+// not counting overflow buckets.
+func (m *Map) LoadFactor() float64 {
+	if m.Swiss {
+		if m.Buckets == 0 {
+			return 0
+		}
+		return float64(m.Count) / float64(m.Buckets)
+	}
+	return float64(m.Count) / float64(uint64(1)<<m.B)
+}
+
+// A mapType describes the layout of the header and buckets of a
+// map type, as found in the Dwarf info.  For a Swiss table, buckets
+// is the directory pointer, the buckets are groups, tophash is their
+// control word and keys and values are the offsets in the first slot.
+type mapType struct {
+	name  string // map[K]V
+	swiss bool
+
+	// offsets of fields in the header; oldbuckets is ^0 if absent
+	count, b, buckets, oldbuckets uint64
+	dirLen                        uint64
+	hdrSize                       uint64
+
+	// layout of a Swiss table: offsets of its group pointer and mask
+	groups, lengthMask, tableSize uint64
+
+	// layout of a bucket; slotSize is 0 unless keys and values
+	// are interleaved, as in a Swiss table
+	bucketSize, bucketCnt           uint64
+	tophash, keys, values, overflow uint64
+	keySize, valueSize, slotSize    uint64
+	keyFields, valueFields          []Field
+
+	nested bool // keys or values may point to maps
+}
+
+// findMapTypes finds the map types in the Dwarf info.  Before go1.24
+// the compiler describes a map[K]V with a header struct hash<K,V> and
+// a bucket struct bucket<K,V>, which typeMap has renamed to
+// map.hdr[K]V and map.bucket[K]V.  From go1.24 on the header is a
+// struct map<K,V>, from which newSwissMapType finds the rest.
+func findMapTypes(d *Dump, t map[dwarf.Offset]dwarfType) map[string]*mapType {
+	structs := map[string]*dwarfStructType{}
+	for _, x := range t {
+		if s, ok := x.(*dwarfStructType); ok {
+			structs[s.name] = s
+		}
+	}
+	m := map[string]*mapType{}
+	for name, hdr := range structs {
+		if !strings.HasPrefix(name, "map.hdr[") {
+			continue
+		}
+		suffix := name[len("map.hdr"):]
+		bucket := structs["map.bucket"+suffix]
+		if bucket == nil {
+			continue
+		}
+		if mt := newMapType(d, "map"+suffix, hdr, bucket); mt != nil {
+			m[name] = mt
+		}
+	}
+	for name, hdr := range structs {
+		if !strings.HasPrefix(name, "map<") {
+			continue
+		}
+		if mt := newSwissMapType(d, hdr); mt != nil {
+			m[name] = mt
+		}
+	}
+	for _, mt := range m {
+		for _, f := range append(mt.keyFields, mt.valueFields...) {
+			if f.Kind == FieldKindPtr && m[adjustTypeName(f.BaseType)] != nil {
+				mt.nested = true
+			}
+		}
+	}
+	return m
+}
+
+func member(s *dwarfStructType, name string) *dwarfTypeMember {
+	for i := range s.members {
+		if s.members[i].name == name {
+			return &s.members[i]
+		}
+	}
+	return nil
+}
+
+// newMapType returns the layout of a map type, or nil if the
+// header and bucket structs don't look as expected.
+func newMapType(d *Dump, name string, hdr, bucket *dwarfStructType) *mapType {
+	count := member(hdr, "count")
+	b := member(hdr, "B")
+	buckets := member(hdr, "buckets")
+	tophash := member(bucket, "tophash")
+	keys := member(bucket, "keys")
+	values := member(bucket, "values")
+	overflow := member(bucket, "overflow")
+	if count == nil || b == nil || buckets == nil || tophash == nil || keys == nil || values == nil || overflow == nil {
+		return nil
+	}
+	karr, kok := keys.type_.(*dwarfArrayType)
+	varr, vok := values.type_.(*dwarfArrayType)
+	n := tophash.type_.Size()
+	if !kok || !vok || karr.elem == nil || varr.elem == nil || n == 0 {
+		return nil
+	}
+	mt := &mapType{
+		name:        name,
+		count:       count.offset,
+		b:           b.offset,
+		buckets:     buckets.offset,
+		oldbuckets:  ^uint64(0),
+		hdrSize:     hdr.Size(),
+		bucketSize:  bucket.Size(),
+		bucketCnt:   n,
+		tophash:     tophash.offset,
+		keys:        keys.offset,
+		values:      values.offset,
+		overflow:    overflow.offset,
+		keySize:     karr.Size() / n,
+		valueSize:   varr.Size() / n,
+		keyFields:   karr.elem.Fields(),
+		valueFields: varr.elem.Fields(),
+	}
+	if old := member(hdr, "oldbuckets"); old != nil {
+		mt.oldbuckets = old.offset
+	}
+	if mt.count+d.PtrSize > mt.hdrSize || mt.buckets+d.PtrSize > mt.hdrSize || mt.b >= mt.hdrSize ||
+		mt.tophash+n > mt.bucketSize || mt.keys+karr.Size() > mt.bucketSize ||
+		mt.values+varr.Size() > mt.bucketSize || mt.overflow+d.PtrSize > mt.bucketSize {
+		return nil
+	}
+	return mt
+}
+
+// underlying returns t without any typedefs.
+func underlying(t dwarfType) dwarfType {
+	for {
+		td, ok := t.(*dwarfTypedef)
+		if !ok {
+			return t
+		}
+		t = td.type_
+	}
+}
+
+// pointee returns the struct that pointer type t points to, or nil.
+func pointee(t dwarfType) *dwarfStructType {
+	p, ok := underlying(t).(*dwarfPtrType)
+	if !ok {
+		return nil
+	}
+	s, _ := underlying(p.elem).(*dwarfStructType)
+	return s
+}
+
+// newSwissMapType returns the layout of a Swiss-table map type, or
+// nil if its structs don't look as expected.  The header map<K,V>
+// points to a directory of tables table<K,V>, each of which points
+// to an array of groups noalg.map.group[K]V.  A small map has no
+// directory; its header points to a single group instead.
+func newSwissMapType(d *Dump, hdr *dwarfStructType) *mapType {
+	used := member(hdr, "used")
+	dirPtr := member(hdr, "dirPtr")
+	dirLen := member(hdr, "dirLen")
+	if used == nil || dirPtr == nil || dirLen == nil {
+		return nil
+	}
+	dir, ok := underlying(dirPtr.type_).(*dwarfPtrType)
+	if !ok {
+		return nil
+	}
+	table := pointee(dir.elem)
+	if table == nil {
+		return nil
+	}
+	groups := member(table, "groups")
+	if groups == nil {
+		return nil
+	}
+	ref, ok := underlying(groups.type_).(*dwarfStructType)
+	if !ok {
+		return nil
+	}
+	data := member(ref, "data")
+	mask := member(ref, "lengthMask")
+	if data == nil || mask == nil {
+		return nil
+	}
+	group := pointee(data.type_)
+	if group == nil || !strings.HasPrefix(group.name, "noalg.map.group[") {
+		return nil
+	}
+	ctrl := member(group, "ctrl")
+	slots := member(group, "slots")
+	if ctrl == nil || slots == nil {
+		return nil
+	}
+	arr, ok := underlying(slots.type_).(*dwarfArrayType)
+	if !ok {
+		return nil
+	}
+	slot, ok := underlying(arr.elem).(*dwarfStructType)
+	if !ok || slot.Size() == 0 {
+		return nil
+	}
+	key := member(slot, "key")
+	elem := member(slot, "elem")
+	if key == nil || elem == nil {
+		return nil
+	}
+	n := arr.Size() / slot.Size()
+	mt := &mapType{
+		name:        "map" + group.name[len("noalg.map.group"):],
+		swiss:       true,
+		count:       used.offset,
+		buckets:     dirPtr.offset,
+		oldbuckets:  ^uint64(0),
+		dirLen:      dirLen.offset,
+		hdrSize:     hdr.Size(),
+		groups:      groups.offset + data.offset,
+		lengthMask:  groups.offset + mask.offset,
+		tableSize:   table.Size(),
+		bucketSize:  group.Size(),
+		bucketCnt:   n,
+		tophash:     ctrl.offset,
+		keys:        slots.offset + key.offset,
+		values:      slots.offset + elem.offset,
+		overflow:    ^uint64(0),
+		keySize:     key.type_.Size(),
+		valueSize:   elem.type_.Size(),
+		slotSize:    slot.Size(),
+		keyFields:   key.type_.Fields(),
+		valueFields: elem.type_.Fields(),
+	}
+	if n == 0 || ctrl.type_.Size() != n ||
+		mt.count+8 > mt.hdrSize || mt.buckets+d.PtrSize > mt.hdrSize || mt.dirLen+d.PtrSize > mt.hdrSize ||
+		mt.groups+d.PtrSize > mt.tableSize || mt.lengthMask+8 > mt.tableSize ||
+		mt.tophash+n > mt.bucketSize || slots.offset+arr.Size() > mt.bucketSize ||
+		key.offset+mt.keySize > mt.slotSize || elem.offset+mt.valueSize > mt.slotSize {
+		return nil
+	}
+	return mt
+}
+
+// IsMap reports whether object x is the header of a map that Map
+// can decode.
+func (d *Dump) IsMap(x ObjId) bool {
+	d.mapOnce.Do(d.findMaps)
+	return d.mapHeaders[x] != nil
+}
+
+// Map decodes the map whose header is object x.  It returns nil if
+// x is not known to be a map header.  Map types come from the Dwarf
+// info, so the dump must be read with its executable.  Dumps from
+// go1.4 on record no types for objects, so there a header is only
+// recognized when a global variable, a local variable or another
+// map refers to it.  Map understands the bucketed maps of go1.3 to
+// go1.23 and the Swiss tables of go1.24 on.  It is safe to call Map
+// from multiple goroutines.
+func (d *Dump) Map(x ObjId) (*Map, error) {
+	d.mapOnce.Do(d.findMaps)
+	mt := d.mapHeaders[x]
+	if mt == nil {
+		return nil, nil
+	}
+	m, err := d.readMap(x, mt)
+	if err != nil {
+		return nil, &ObjectError{x, d.Addr(x), err}
+	}
+	return m, nil
+}
+
+// mapTypeOf returns the layout of the maps that a pointer
+// field with the given base type points to, or nil.
+func (d *Dump) mapTypeOf(f Field) *mapType {
+	if f.Kind != FieldKindPtr || f.BaseType == "" {
+		return nil
+	}
+	return d.mapTypes[adjustTypeName(f.BaseType)]
+}
+
+// findMaps finds the map headers in the heap, by their type or by
+// the type of the variables and maps that point to them.  Objects
+// that can't be read are skipped; Contents reports their errors.
+func (d *Dump) findMaps() {
+	d.mapHeaders = map[ObjId]*mapType{}
+	if len(d.mapTypes) == 0 {
+		return
+	}
+	var q []ObjId
+	add := func(p uint64, mt *mapType) {
+		x := d.FindObj(p)
+		if x != ObjNil && d.Addr(x) == p && d.mapHeaders[x] == nil {
+			d.mapHeaders[x] = mt
+			q = append(q, x)
+		}
+	}
+	scan := func(data []byte, fields []Field) {
+		for _, f := range fields {
+			if mt := d.mapTypeOf(f); mt != nil && f.Offset+d.PtrSize <= uint64(len(data)) {
+				add(readPtr(d, data[f.Offset:]), mt)
+			}
+		}
+	}
+
+	// Objects typed in the dump itself.
+	hdrs := make([]*mapType, len(d.FTList))
+	pointsToMap := make([]bool, len(d.FTList))
+	for i, ft := range d.FTList {
+		hdrs[i] = d.mapTypes[ft.Name]
+		for _, f := range ft.Fields {
+			if d.mapTypeOf(f) != nil {
+				pointsToMap[i] = true
+			}
+		}
+	}
+	var b []byte
+	for i, n := 0, d.NumObjects(); i < n; i++ {
+		x := ObjId(i)
+		id := d.Ft(x).Id
+		if mt := hdrs[id]; mt != nil && d.mapHeaders[x] == nil {
+			d.mapHeaders[x] = mt
+			q = append(q, x)
+		}
+		if pointsToMap[id] {
+			var err error
+			b, err = d.ContentsInto(x, b)
+			if err == nil {
+				scan(b, d.Ft(x).Fields)
+			}
+		}
+	}
+
+	// Variables.
+	for _, x := range []*Data{d.Data, d.Bss} {
+		if x != nil {
+			scan(x.Data, x.Fields)
+		}
+	}
+	for _, f := range d.Frames {
+		scan(f.Data, f.Fields)
+	}
+
+	// Maps in maps.
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		mt := d.mapHeaders[x]
+		if !mt.nested {
+			continue
+		}
+		m, err := d.readMap(x, mt)
+		if err != nil {
+			continue
+		}
+		for _, e := range m.Entries {
+			scan(e.Key, mt.keyFields)
+			scan(e.Value, mt.valueFields)
+		}
+	}
+}
+
+func (d *Dump) readMap(x ObjId, mt *mapType) (*Map, error) {
+	hdr, err := d.ContentsInto(x, nil)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(hdr)) < mt.hdrSize {
+		return nil, fmt.Errorf("map header is %d bytes, want %d", len(hdr), mt.hdrSize)
+	}
+	m := &Map{
+		Type:        mt.name,
+		Swiss:       mt.swiss,
+		KeyFields:   mt.keyFields,
+		ValueFields: mt.valueFields,
+	}
+	if mt.swiss {
+		m.Count = int(d.Order.Uint64(hdr[mt.count:]))
+		if err := d.readTables(m, mt, hdr); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	m.Count = int(readPtr(d, hdr[mt.count:]))
+	m.B = hdr[mt.b]
+	if m.B >= 64 {
+		return nil, fmt.Errorf("bad map B %d", m.B)
+	}
+	seen := map[uint64]bool{}
+	n := uint64(1) << m.B
+	if err := d.readBuckets(m, mt, readPtr(d, hdr[mt.buckets:]), n, seen); err != nil {
+		return nil, err
+	}
+	if mt.oldbuckets != ^uint64(0) {
+		// A growing map still has entries in its old buckets.
+		// There are half as many, unless the map is growing
+		// in place to get rid of overflow buckets.
+		old := readPtr(d, hdr[mt.oldbuckets:])
+		if y := d.FindObj(old); y != ObjNil && n > 1 && d.Addr(y)+d.Size(y)-old < n*mt.bucketSize {
+			n /= 2
+		}
+		if err := d.readBuckets(m, mt, old, n, seen); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// readBuckets adds to m the entries in the n buckets at addr and
+// their overflow buckets.  seen holds the overflow buckets already
+// walked, to protect against cycles in a corrupt heap.
+func (d *Dump) readBuckets(m *Map, mt *mapType, addr, n uint64, seen map[uint64]bool) error {
+	if addr == 0 {
+		return nil
+	}
+	bucket := func(p uint64) ([]byte, error) {
+		return d.bytesAt(p, mt.bucketSize, "map bucket")
+	}
+	top := d.minTopHash()
+	for i := uint64(0); i < n; i++ {
+		b, err := bucket(addr + i*mt.bucketSize)
+		if err != nil {
+			return err
+		}
+		for {
+			m.Buckets++
+			m.BucketBytes += mt.bucketSize
+			m.Slots += int(mt.bucketCnt)
+			for j := uint64(0); j < mt.bucketCnt; j++ {
+				if b[mt.tophash+j] < top {
+					continue // empty or evacuated
+				}
+				k := b[mt.keys+j*mt.keySize:][:mt.keySize]
+				v := b[mt.values+j*mt.valueSize:][:mt.valueSize]
+				d.addEntry(m, mt, k, v)
+			}
+			ovf := readPtr(d, b[mt.overflow:])
+			if ovf == 0 {
+				break
+			}
+			if seen[ovf] {
+				return errors.New("cycle in map overflow buckets")
+			}
+			seen[ovf] = true
+			if b, err = bucket(ovf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readTables adds to m the entries of the Swiss table whose header
+// is hdr.  Several directory entries may share a table.
+func (d *Dump) readTables(m *Map, mt *mapType, hdr []byte) error {
+	dirPtr := readPtr(d, hdr[mt.buckets:])
+	dirLen := readPtr(d, hdr[mt.dirLen:])
+	if dirPtr == 0 {
+		return nil
+	}
+	if dirLen == 0 {
+		// A small map: dirPtr points to its only group.
+		return d.readGroups(m, mt, dirPtr, 1)
+	}
+	if dirLen > math.MaxUint64/d.PtrSize {
+		return fmt.Errorf("bad map directory length %d", dirLen)
+	}
+	dir, err := d.bytesAt(dirPtr, dirLen*d.PtrSize, "map directory")
+	if err != nil {
+		return err
+	}
+	seen := map[uint64]bool{}
+	for i := uint64(0); i < dirLen; i++ {
+		p := readPtr(d, dir[i*d.PtrSize:])
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		t, err := d.bytesAt(p, mt.tableSize, "map table")
+		if err != nil {
+			return err
+		}
+		mask := d.Order.Uint64(t[mt.lengthMask:])
+		if mask >= math.MaxUint64/mt.bucketSize {
+			return fmt.Errorf("bad map table length mask %x", mask)
+		}
+		if err := d.readGroups(m, mt, readPtr(d, t[mt.groups:]), mask+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGroups adds to m the entries in the n Swiss-table groups at
+// addr.  Byte j of a group's control word describes slot j; its top
+// bit is clear if the slot is full.
+func (d *Dump) readGroups(m *Map, mt *mapType, addr, n uint64) error {
+	b, err := d.bytesAt(addr, n*mt.bucketSize, "map group")
+	if err != nil {
+		return err
+	}
+	bigEndian := d.Order == binary.BigEndian
+	for i := uint64(0); i < n; i++ {
+		g := b[i*mt.bucketSize:][:mt.bucketSize]
+		m.Buckets++
+		m.BucketBytes += mt.bucketSize
+		m.Slots += int(mt.bucketCnt)
+		for j := uint64(0); j < mt.bucketCnt; j++ {
+			c := j
+			if bigEndian {
+				c = mt.bucketCnt - 1 - j
+			}
+			if g[mt.tophash+c]&0x80 != 0 {
+				continue // empty or deleted
+			}
+			k := g[mt.keys+j*mt.slotSize:][:mt.keySize]
+			v := g[mt.values+j*mt.slotSize:][:mt.valueSize]
+			d.addEntry(m, mt, k, v)
+		}
+	}
+	return nil
+}
+
+// addEntry adds the key k and value v to m.
+func (d *Dump) addEntry(m *Map, mt *mapType, k, v []byte) {
+	m.Entries = append(m.Entries, MapEntry{
+		Key:        k,
+		Value:      v,
+		KeyEdges:   d.appendFields(nil, k, mt.keyFields),
+		ValueEdges: d.appendFields(nil, v, mt.valueFields),
+	})
+}
+
+// bytesAt returns the n bytes at address p, which must all lie in
+// one object.  what names them in errors.
+func (d *Dump) bytesAt(p, n uint64, what string) ([]byte, error) {
+	x := d.FindObj(p)
+	if x == ObjNil {
+		return nil, fmt.Errorf("%s %x is not in the heap", what, p)
+	}
+	b, err := d.ContentsInto(x, nil)
+	if err != nil {
+		return nil, err
+	}
+	off := p - d.Addr(x)
+	if off > uint64(len(b)) || n > uint64(len(b))-off {
+		return nil, fmt.Errorf("%s %x extends past its object", what, p)
+	}
+	return b[off : off+n], nil
+}
+
+// minTopHash returns the smallest tophash of a full bucket slot.
+// Smaller values mark empty and evacuated slots; go1.12 added one.
+// Dumps before go1.17 hold GOEXPERIMENT where later ones hold the
+// runtime version, so unless GoVersion names a release from go1.12
+// on, minTopHash assumes the older layout.
+func (d *Dump) minTopHash() uint8 {
+	if n, ok := goMinor(d.GoVersion); ok && n >= 12 {
+		return 5
+	}
+	return 4
+}
+
+// goMinor returns the minor version in a runtime version string
+// like "go1.11.5" or "devel go1.12-abcdef", and whether v has that
+// form.
+func goMinor(v string) (int, bool) {
+	v = strings.TrimPrefix(v, "devel ")
+	if !strings.HasPrefix(v, "go1.") {
+		return 0, false
+	}
+	v = v[len("go1."):]
+	if i := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		v = v[:i]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,64 @@
+package read
+
+import "testing"
+
+func TestMinTopHash(t *testing.T) {
+	for _, tt := range []struct {
+		version Version
+		goVer   string
+		want    uint8
+	}{
+		{Go17, "go1.11.5", 4},
+		{Go17, "go1.12", 5},
+		{Go17, "go1.23.4", 5},
+		{Go17, "devel go1.12-abcdef Mon Jan 1", 5},
+		{Go17, "", 4},           // go1.7 to go1.16: empty GOEXPERIMENT
+		{Go17, "fieldtrack", 4}, // go1.7 to go1.16: GOEXPERIMENT
+		{Go14, "", 4},
+	} {
+		d := &Dump{Version: tt.version}
+		d.GoVersion = tt.goVer
+		if got := d.minTopHash(); got != tt.want {
+			t.Errorf("minTopHash() for %v %q = %d, want %d", tt.version, tt.goVer, got, tt.want)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	name := writeTestDump(t, "maps.dump")
+	for _, opts := range [][]Option{nil, {Cache("")}, {Cache("")}} {
+		d := openTest(t, name, opts...)
+		f, data := global(t, d, "main.m")
+		x := d.FindObj(readPtr(d, data[f.Offset:]))
+		if x == ObjNil {
+			t.Fatal("main.m is not in the heap")
+		}
+		if !d.IsMap(x) {
+			t.Skipf("maps of %s dumps are not understood", d.GoVersion)
+		}
+		m, err := d.Map(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != "map[string]*[16]int" || m.Count != 5 || len(m.Entries) != 5 {
+			t.Fatalf("main.m is a %s of %d entries with %d decoded, want map[string]*[16]int of 5",
+				m.Type, m.Count, len(m.Entries))
+		}
+		keys := map[string]bool{}
+		for _, e := range m.Entries {
+			k, ok := d.StringValue(e.Key, m.KeyFields[0])
+			if !ok {
+				t.Fatalf("can't read key %x", e.Key)
+			}
+			keys[k] = true
+			if len(e.ValueEdges) != 1 || d.Size(e.ValueEdges[0].To) != 16*d.PtrSize {
+				t.Errorf("value of %q has edges %v, want one to a [16]int", k, e.ValueEdges)
+			}
+		}
+		for _, k := range []string{"a", "b", "c", "d", "e"} {
+			if !keys[k] {
+				t.Errorf("key %q missing from %v", k, keys)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package read
+
+import (
+	"sort"
+)
+
+// InUseObjects returns the number of objects allocated at e
+// that had not been freed when the dump was written.
+func (e *MemProfEntry) InUseObjects() uint64 {
+	return e.Allocs - e.Frees
+}
+
+// InUseBytes returns the size of the objects allocated at e
+// that had not been freed when the dump was written.
+func (e *MemProfEntry) InUseBytes() uint64 {
+	return e.InUseObjects() * e.Size
+}
+
+// AllocSite returns the allocation site of object x, or nil if x
+// was not sampled by the memory profiler.  Only about one object in
+// runtime.MemProfileRate bytes is sampled.
+func (d *Dump) AllocSite(x ObjId) *MemProfEntry {
+	d.allocOnce.Do(d.linkAllocSamples)
+	return d.allocSites[x]
+}
+
+func (d *Dump) linkAllocSamples() {
+	d.allocSites = map[ObjId]*MemProfEntry{}
+	for _, s := range d.AllocSamples {
+		if s.Prof == nil {
+			continue
+		}
+		x := d.FindObj(s.Addr)
+		if x != ObjNil && d.Addr(x) == s.Addr {
+			d.allocSites[x] = s.Prof
+		}
+	}
+}
+
+// SiteStats summarizes the sampled objects in a dump that were
+// allocated at one site.
+type SiteStats struct {
+	Site    *MemProfEntry
+	Objects int    // number of sampled objects in the dump
+	Bytes   uint64 // total size of those objects
+}
+
+// AllocSiteStats groups the sampled objects in the dump by their
+// allocation site.  If keep is not nil, only objects for which it
+// returns true are counted.  The result is sorted by decreasing
+// Bytes.
+func (d *Dump) AllocSiteStats(keep func(ObjId) bool) []SiteStats {
+	d.allocOnce.Do(d.linkAllocSamples)
+	index := map[*MemProfEntry]int{}
+	seen := map[ObjId]bool{}
+	var stats []SiteStats
+	for _, s := range d.AllocSamples {
+		// Go through the samples, not the map, so the
+		// order is deterministic.
+		x := d.FindObj(s.Addr)
+		if x == ObjNil || d.allocSites[x] != s.Prof || s.Prof == nil || seen[x] {
+			continue
+		}
+		seen[x] = true
+		if keep != nil && !keep(x) {
+			continue
+		}
+		i, ok := index[s.Prof]
+		if !ok {
+			i = len(stats)
+			index[s.Prof] = i
+			stats = append(stats, SiteStats{Site: s.Prof})
+		}
+		stats[i].Objects++
+		stats[i].Bytes += d.Size(x)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Bytes > stats[j].Bytes
+	})
+	return stats
+}
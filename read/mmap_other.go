@@ -0,0 +1,16 @@
+//go:build !unix
+
+package read
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("mmap not supported on this system")
+}
+
+func munmap(b []byte) error {
+	return nil
+}
@@ -0,0 +1,61 @@
+//go:build unix
+
+package read
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestEdgesIntoMixed interleaves EdgesInto calls on a mapped and an
+// unmapped dump, which share the scratch space of EdgesInto.
+func TestEdgesIntoMixed(t *testing.T) {
+	name := testDump(t)
+	mapped := openTest(t, name, Mmap())
+	plain := openTest(t, name)
+	if mapped.mapped == nil {
+		t.Fatal("dump not mapped")
+	}
+	if mapped.NumObjects() != plain.NumObjects() {
+		t.Fatalf("mapped dump has %d objects, unmapped %d", mapped.NumObjects(), plain.NumObjects())
+	}
+	for i := 0; i < plain.NumObjects(); i++ {
+		x := ObjId(i)
+		em, err := mapped.EdgesInto(x, nil)
+		if err != nil {
+			t.Fatalf("mapped: %v", err)
+		}
+		ep, err := plain.EdgesInto(x, nil)
+		if err != nil {
+			t.Fatalf("unmapped: %v", err)
+		}
+		if !reflect.DeepEqual(em, ep) {
+			t.Fatalf("object %x: mapped edges %v, unmapped %v", plain.Addr(x), em, ep)
+		}
+	}
+}
+
+func TestEdgesIntoAfterClose(t *testing.T) {
+	for _, mmap := range []bool{false, true} {
+		var opts []Option
+		if mmap {
+			opts = append(opts, Mmap())
+		}
+		d := openTest(t, testDump(t), opts...)
+		if _, err := d.EdgesInto(0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < d.NumObjects(); i++ {
+			_, err := d.EdgesInto(ObjId(i), nil)
+			var oe *ObjectError
+			if !errors.As(err, &oe) || !errors.Is(err, os.ErrClosed) {
+				t.Fatalf("mmap=%v: EdgesInto after Close returned %v, want an *ObjectError wrapping os.ErrClosed", mmap, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package read
+
+// objectTable holds the objects of a heap dump.  There will be a
+// lot of them, so rather than a slice of structs it keeps parallel
+// arrays, which need 20 bytes per object.
+type objectTable struct {
+	addr   []uint64
+	offset []int64  // position of object contents in dump file
+	ft     []uint32 // index of full type in Dump.FTList
+}
+
+func (t *objectTable) add(ft *FullType, offset int64, addr uint64) {
+	t.addr = append(t.addr, addr)
+	t.offset = append(t.offset, offset)
+	t.ft = append(t.ft, uint32(ft.Id))
+}
+
+// trim releases the unused capacity left over by add.
+func (t *objectTable) trim() {
+	t.addr = append([]uint64(nil), t.addr...)
+	t.offset = append([]int64(nil), t.offset...)
+	t.ft = append([]uint32(nil), t.ft...)
+}
+
+// objectTable sorts by address.
+func (t *objectTable) Len() int           { return len(t.addr) }
+func (t *objectTable) Less(i, j int) bool { return t.addr[i] < t.addr[j] }
+func (t *objectTable) Swap(i, j int) {
+	t.addr[i], t.addr[j] = t.addr[j], t.addr[i]
+	t.offset[i], t.offset[j] = t.offset[j], t.offset[i]
+	t.ft[i], t.ft[j] = t.ft[j], t.ft[i]
+}
@@ -0,0 +1,304 @@
+package read
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// An Option configures how Open loads a heap dump.
+type Option func(*config)
+
+type config struct {
+	logger  *log.Logger
+	tempDir string
+	mmap    bool
+	workers int
+
+	finalizerRoots bool
+	stringLimit    int
+
+	cache     bool
+	cachePath string      // index file
+	stamp     *cacheStamp // identifies the dump, set by Open
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		logger:      log.New(os.Stderr, "", log.LstdFlags),
+		workers:     defaultWorkers(),
+		stringLimit: defaultStringLimit,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Logger directs warnings found while loading (for instance, Dwarf
+// info that doesn't match the dump) to l.  A nil l discards them.
+// By default warnings are written to standard error.
+func Logger(l *log.Logger) Option {
+	return func(c *config) {
+		c.logger = l
+	}
+}
+
+// TempDir sets the directory OpenReader spools dumps into.
+// The default is the system temporary directory.
+func TempDir(dir string) Option {
+	return func(c *config) {
+		c.tempDir = dir
+	}
+}
+
+// Mmap makes Open and OpenReader map the dump file into memory
+// instead of reading object contents with a system call each time.
+// OpenReaderAt maps its input only if it is an *os.File.  Mapping is
+// only supported on Unix systems.
+func Mmap() Option {
+	return func(c *config) {
+		c.mmap = true
+	}
+}
+
+// FinalizerRoots makes the functions of pending finalizers, and the
+// objects referred to by objects with finalizers, roots of the heap,
+// as they are for the garbage collector.  See Roots.
+func FinalizerRoots() Option {
+	return func(c *config) {
+		c.finalizerRoots = true
+	}
+}
+
+func (d *Dump) logf(format string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger.Printf(format, args...)
+	}
+}
+
+// Open reads the heap dump in dumpname.  If dumpname is "-", the
+// dump is read from standard input.  Dumps compressed with gzip or
+// bzip2 are recognized by their contents and decompressed into a
+// temporary file, as OpenReader does.  If execname is not empty, the
+// Dwarf info in that executable is used to name types, fields,
+// locals and globals.  Problems with the dump file are reported
+// as a *FormatError.
+func Open(dumpname, execname string, opts ...Option) (*Dump, error) {
+	if dumpname == "-" {
+		return OpenReader(os.Stdin, execname, opts...)
+	}
+	f, err := os.Open(dumpname)
+	if err != nil {
+		return nil, err
+	}
+	zr, compressed, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c := newConfig(opts)
+	if c.cache {
+		if c.cachePath == "" {
+			c.cachePath = dumpname + ".idx"
+		}
+		c.stamp, err = makeStamp(fi, execname, c)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if compressed {
+		defer f.Close()
+		return openSpooled(zr, execname, c)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	d, err := openReaderAt(f, fi.Size(), execname, c)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d.closer = f
+	return d, nil
+}
+
+// OpenReaderAt is like Open but reads the size bytes of the dump
+// from r.  Object contents are read from r as needed, so r must
+// remain valid until the Dump is no longer used.
+func OpenReaderAt(r io.ReaderAt, size int64, execname string, opts ...Option) (*Dump, error) {
+	return openReaderAt(r, size, execname, newConfig(opts))
+}
+
+// OpenReader is like Open but reads the dump from r.  Since object
+// contents must be available later, r is first copied to a
+// temporary file, which is removed by Close.  A gzip or bzip2
+// compressed dump is decompressed while it is copied.
+func OpenReader(r io.Reader, execname string, opts ...Option) (*Dump, error) {
+	zr, _, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	return openSpooled(zr, execname, newConfig(opts))
+}
+
+// openSpooled copies r to a temporary file and loads the dump from it.
+func openSpooled(r io.Reader, execname string, c *config) (*Dump, error) {
+	f, err := ioutil.TempFile(c.tempDir, "heapdump")
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{f}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	d, err := openReaderAt(f, n, execname, c)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	d.closer = s
+	return d, nil
+}
+
+func openReaderAt(r io.ReaderAt, size int64, execname string, c *config) (*Dump, error) {
+	var mapped []byte
+	if f, ok := r.(*os.File); ok && c.mmap && size > 0 {
+		m, err := mmapFile(f, size)
+		if err != nil {
+			return nil, err
+		}
+		mapped = m
+		r = bytes.NewReader(m)
+	}
+	d, err := load(r, size, execname, c)
+	if err != nil {
+		if mapped != nil {
+			munmap(mapped)
+		}
+		return nil, err
+	}
+	d.mapped = mapped
+	return d, nil
+}
+
+// load parses, names and links the dump in r, or loads it from
+// the index file if there is an up to date one.
+func load(r io.ReaderAt, size int64, execname string, c *config) (*Dump, error) {
+	if c.stamp != nil {
+		d, err := readCache(c.cachePath, c.stamp, r, c.workers)
+		if err == nil {
+			d.logger = c.logger
+			d.stringLimit = c.stringLimit
+			loadExec(d, execname)
+			return d, nil
+		}
+		if !os.IsNotExist(err) && err != errStaleCache {
+			if c.logger != nil {
+				c.logger.Printf("ignoring index file: %v", err)
+			}
+		}
+	}
+	d, err := rawRead(r, size, c.workers)
+	if err != nil {
+		return nil, err
+	}
+	d.logger = c.logger
+	d.stringLimit = c.stringLimit
+	if execname != "" {
+		err = nameWithDwarf(d, execname)
+	} else {
+		nameFallback(d)
+	}
+	if err == nil {
+		err = nameFullTypes(d, c.workers)
+	}
+	if err == nil {
+		err = link(d, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.stamp != nil {
+		if err := writeCache(c.cachePath, c.stamp, d, c.workers); err != nil {
+			d.logf("can't write index file: %v", err)
+		}
+	}
+	loadExec(d, execname)
+	return d, nil
+}
+
+// loadExec loads the symbols of the executable, if any, and uses
+// them to find the source positions of the frames and goroutines.
+// A dump is still useful without them, so failure is only logged.
+func loadExec(d *Dump, execname string) {
+	if execname == "" {
+		return
+	}
+	syms, err := loadSymbols(execname)
+	if err != nil {
+		d.logf("%v", err)
+		return
+	}
+	d.syms = syms
+	symbolize(d)
+}
+
+// Read is like Open but exits the program if the dump can't be read.
+func Read(dumpname, execname string) *Dump {
+	d, err := Open(dumpname, execname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return d
+}
+
+// Close releases any file Open or OpenReader opened for the dump,
+// and its mapping.  After Close, reading object contents or edges
+// fails with an *ObjectError wrapping os.ErrClosed.
+func (d *Dump) Close() error {
+	var err error
+	d.r = closedReader{}
+	if d.mapped != nil {
+		err = munmap(d.mapped)
+		d.mapped = nil
+	}
+	if d.closer != nil {
+		if cerr := d.closer.Close(); err == nil {
+			err = cerr
+		}
+		d.closer = nil
+	}
+	return err
+}
+
+// closedReader replaces the reader of a closed Dump.
+type closedReader struct{}
+
+func (closedReader) ReadAt(b []byte, off int64) (int, error) {
+	return 0, os.ErrClosed
+}
+
+// A spool is a temporary file holding a copy of a dump.
+type spool struct {
+	*os.File
+}
+
+func (s *spool) Close() error {
+	err := s.File.Close()
+	if rerr := os.Remove(s.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
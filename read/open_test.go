@@ -0,0 +1,50 @@
+package read
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenReaderAt(t *testing.T) {
+	name := testDump(t)
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)), testExec, Logger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	compareDumps(t, openTest(t, name), d)
+}
+
+func TestOpenReader(t *testing.T) {
+	name := testDump(t)
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dir, err := ioutil.TempDir(testDir, "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hide f's ReaderAt, so the dump must be spooled.
+	d, err := OpenReader(struct{ *os.File }{f}, testExec, Logger(nil), TempDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files in the spool directory, want 1", len(files))
+	}
+	compareDumps(t, openTest(t, name), d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("spool file %s not removed by Close", files[0].Name())
+	}
+}
@@ -0,0 +1,412 @@
+package read
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Loading uses multiple goroutines when Parallelism allows it.  The
+// dump format is not self-synchronizing, so one goroutine pre-scans
+// the dump for record boundaries.  It reads only the headers of
+// object records, jumping over their contents, and hands runs of
+// them to the other goroutines to decode.  The rest of the work
+// (computing object layouts, sorting, indexing, finding edges,
+// naming types) is spread over all the goroutines too.  Decoded
+// records are loaded in dump order, so the result is the same Dump
+// the serial path builds.
+
+// Parallelism sets the number of goroutines used to load a dump.
+// The default is runtime.GOMAXPROCS(0).  n <= 1 loads serially.
+func Parallelism(n int) Option {
+	return func(c *config) {
+		c.workers = n
+	}
+}
+
+func defaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallelFor calls f on consecutive ranges that cover [0,n),
+// using up to workers goroutines, and waits for all calls to return.
+func parallelFor(n, workers int, f func(lo, hi int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		if n > 0 {
+			f(0, n)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
+
+// Don't bother splitting work smaller than this.  A variable so
+// that tests can exercise the parallel code on small dumps.
+var minParallel = 1 << 14
+
+// slice returns a view of objects [lo,hi) of t.  Sorting the
+// view sorts that part of t.
+func (t *objectTable) slice(lo, hi int) *objectTable {
+	return &objectTable{t.addr[lo:hi], t.offset[lo:hi], t.ft[lo:hi]}
+}
+
+// sortObjects sorts t by address.  It sorts runs of t in parallel
+// and then merges them.  Object addresses are distinct, so the
+// order is the same as sort.Sort(t) gives.
+func sortObjects(t *objectTable, workers int) {
+	n := t.Len()
+	if workers <= 1 || n < minParallel {
+		sort.Sort(t)
+		return
+	}
+	if sort.IsSorted(t) {
+		// The runtime dumps objects in address order, mostly.
+		return
+	}
+	bounds := make([]int, workers+1)
+	for w := range bounds {
+		bounds[w] = n * w / workers
+	}
+	parallelFor(workers, workers, func(lo, hi int) {
+		for w := lo; w < hi; w++ {
+			sort.Sort(t.slice(bounds[w], bounds[w+1]))
+		}
+	})
+
+	src := t
+	dst := &objectTable{make([]uint64, n), make([]int64, n), make([]uint32, n)}
+	for len(bounds) > 2 {
+		var next []int
+		var wg sync.WaitGroup
+		for i := 0; i+1 < len(bounds); i += 2 {
+			next = append(next, bounds[i])
+			if i+2 == len(bounds) {
+				// odd run out, just copy it
+				lo, hi := bounds[i], bounds[i+1]
+				copy(dst.addr[lo:hi], src.addr[lo:hi])
+				copy(dst.offset[lo:hi], src.offset[lo:hi])
+				copy(dst.ft[lo:hi], src.ft[lo:hi])
+				continue
+			}
+			lo, mid, hi := bounds[i], bounds[i+1], bounds[i+2]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mergeObjects(dst, src, lo, mid, hi)
+			}()
+		}
+		next = append(next, n)
+		wg.Wait()
+		src, dst = dst, src
+		bounds = next
+	}
+	if src != t {
+		*t = *src
+	}
+}
+
+// mergeObjects merges the sorted runs src[lo:mid] and src[mid:hi]
+// into dst[lo:hi].
+func mergeObjects(dst, src *objectTable, lo, mid, hi int) {
+	i, j := lo, mid
+	for k := lo; k < hi; k++ {
+		var x int
+		if j == hi || i < mid && src.addr[i] < src.addr[j] {
+			x = i
+			i++
+		} else {
+			x = j
+			j++
+		}
+		dst.addr[k] = src.addr[x]
+		dst.offset[k] = src.offset[x]
+		dst.ft[k] = src.ft[x]
+	}
+}
+
+// buildIndex initializes d.idx, the FindObj bucket index.  Each
+// bucket holds the lowest id of the objects that intersect it, or
+// NumObjects() if there are none.
+func buildIndex(d *Dump, workers int) {
+	n := d.NumObjects()
+	d.idx = make([]uint32, (d.HeapEnd-d.HeapStart+bucketSize-1)/bucketSize)
+	if workers <= 1 || n < minParallel {
+		for i := len(d.idx) - 1; i >= 0; i-- {
+			d.idx[i] = uint32(n)
+		}
+		for i := n - 1; i >= 0; i-- {
+			// Note: we iterate in reverse order so that the object with
+			// the lowest address that intersects a bucket will win.
+			x := ObjId(i)
+			lo := (d.Addr(x) - d.HeapStart) / bucketSize
+			hi := (d.Addr(x) + d.Size(x) - 1 - d.HeapStart) / bucketSize
+			for j := lo; j <= hi; j++ {
+				d.idx[j] = uint32(i)
+			}
+		}
+		return
+	}
+	parallelFor(len(d.idx), workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			d.idx[i] = uint32(n)
+		}
+	})
+	// Buckets on the boundary between two ranges of objects are
+	// written by two goroutines, so keep the minimum atomically.
+	parallelFor(n, workers, func(lo, hi int) {
+		for i := hi - 1; i >= lo; i-- {
+			x := ObjId(i)
+			blo := (d.Addr(x) - d.HeapStart) / bucketSize
+			bhi := (d.Addr(x) + d.Size(x) - 1 - d.HeapStart) / bucketSize
+			for j := blo; j <= bhi; j++ {
+				for {
+					old := atomic.LoadUint32(&d.idx[j])
+					if old <= uint32(i) || atomic.CompareAndSwapUint32(&d.idx[j], old, uint32(i)) {
+						break
+					}
+				}
+			}
+		}
+	})
+}
+
+// appendFieldsParallel is like appendFields, but splits a long
+// field list among workers goroutines.
+func (d *Dump) appendFieldsParallel(edges []Edge, data []byte, fields []Field, workers int) []Edge {
+	if workers <= 1 || len(fields) < minParallel {
+		return d.appendFields(edges, data, fields)
+	}
+	parts := make([][]Edge, workers)
+	parallelFor(workers, workers, func(lo, hi int) {
+		for w := lo; w < hi; w++ {
+			parts[w] = d.appendFields(nil, data, fields[len(fields)*w/workers:len(fields)*(w+1)/workers])
+		}
+	})
+	for _, p := range parts {
+		edges = append(edges, p...)
+	}
+	return edges
+}
+
+// Object records are passed from the scanning goroutine to the
+// decoding goroutines in batches of this size.  A variable so that
+// tests can exercise batching on small dumps.
+var loadBatchSize = 4096
+
+// A loadBatch is a run of consecutive records of a dump: object
+// records from start to end, followed by at most one other record.
+type loadBatch struct {
+	start, end int64
+	n          int            // number of object records
+	objs       []ObjectRecord // decoded by a worker
+	keys       []tkey         // full type keys of objs
+	err        error          // from decoding objs
+	done       chan struct{}  // closed when objs and keys are ready
+	other      func() error   // loads the non-object record, if any
+}
+
+var errStopped = errors.New("load stopped")
+
+// pipe is the Visitor run by the scanning goroutine.  It batches
+// records up and hands them to the decoding workers and to the
+// loading goroutine, in order.
+type pipe struct {
+	l     *loader
+	r     io.ReaderAt
+	f     *format
+	b     *loadBatch
+	work  chan *loadBatch // to decoding workers
+	order chan *loadBatch // to loading goroutine
+	stop  chan struct{}   // closed if loading fails
+}
+
+func (p *pipe) flush() error {
+	b := p.b
+	p.b = &loadBatch{done: make(chan struct{})}
+	if b.n == 0 {
+		close(b.done)
+	} else {
+		select {
+		case p.work <- b:
+		case <-p.stop:
+			return errStopped
+		}
+	}
+	select {
+	case p.order <- b:
+		return nil
+	case <-p.stop:
+		return errStopped
+	}
+}
+
+// then queues f to load a non-object record.
+func (p *pipe) then(f func() error) error {
+	p.b.other = f
+	return p.flush()
+}
+
+// span adds the object record from start to end to the batch.
+func (p *pipe) span(start, end int64) error {
+	b := p.b
+	if b.n == 0 {
+		b.start = start
+	}
+	b.end = end
+	b.n++
+	if b.n == loadBatchSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// decode decodes the object records of b, and computes their keys.
+func (p *pipe) decode(b *loadBatch) {
+	defer close(b.done)
+	r := newReaderAt(p.r, b.start, b.end)
+	b.objs = make([]ObjectRecord, b.n)
+	b.keys = make([]tkey, b.n)
+	for i := range b.objs {
+		start := r.Count()
+		readUint64(r) // tagObject, as the pre-scan found
+		o := p.f.readObject(r, p.f)
+		if r.err != nil {
+			b.err = &FormatError{start, tagObject, r.err}
+			b.objs = b.objs[:i]
+			return
+		}
+		o.start = start
+		b.objs[i] = o
+		b.keys[i] = objKey(&o)
+	}
+}
+
+// Object is not called: the pre-scan passes object records to span.
+func (p *pipe) Object(o *ObjectRecord) error {
+	return errors.New("object record not pre-scanned")
+}
+
+func (p *pipe) Params(x *Params) error { return p.then(func() error { return p.l.Params(x) }) }
+func (p *pipe) Type(x *Type) error     { return p.then(func() error { return p.l.Type(x) }) }
+func (p *pipe) GoRoutine(x *GoRoutine) error {
+	return p.then(func() error { return p.l.GoRoutine(x) })
+}
+func (p *pipe) StackFrame(x *StackFrame) error {
+	return p.then(func() error { return p.l.StackFrame(x) })
+}
+func (p *pipe) OtherRoot(x *OtherRoot) error {
+	return p.then(func() error { return p.l.OtherRoot(x) })
+}
+func (p *pipe) Finalizer(x *Finalizer) error {
+	return p.then(func() error { return p.l.Finalizer(x) })
+}
+func (p *pipe) QFinalizer(x *QFinalizer) error {
+	return p.then(func() error { return p.l.QFinalizer(x) })
+}
+func (p *pipe) Data(x *Data) error         { return p.then(func() error { return p.l.Data(x) }) }
+func (p *pipe) Bss(x *Data) error          { return p.then(func() error { return p.l.Bss(x) }) }
+func (p *pipe) OSThread(x *OSThread) error { return p.then(func() error { return p.l.OSThread(x) }) }
+func (p *pipe) MemStats(x *runtime.MemStats) error {
+	return p.then(func() error { return p.l.MemStats(x) })
+}
+func (p *pipe) Defer(x *Defer) error          { return p.then(func() error { return p.l.Defer(x) }) }
+func (p *pipe) Panic(x *Panic) error          { return p.then(func() error { return p.l.Panic(x) }) }
+func (p *pipe) MemProf(x *MemProfEntry) error { return p.then(func() error { return p.l.MemProf(x) }) }
+func (p *pipe) AllocSample(x *AllocSample) error {
+	return p.then(func() error { return p.l.AllocSample(x) })
+}
+func (p *pipe) Itab(addr uint64, ptr bool) error {
+	return p.then(func() error { return p.l.Itab(addr, ptr) })
+}
+
+// scanParallel is like scanAt(r, size, l), but pre-scans the dump in
+// one goroutine, decodes object records in workers-1 others, and has
+// l load them in the calling goroutine.
+func scanParallel(r io.ReaderAt, size int64, l *loader, workers int) error {
+	br := newReaderAt(r, 0, size)
+	f, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	p := &pipe{
+		l:     l,
+		r:     r,
+		f:     f,
+		b:     &loadBatch{done: make(chan struct{})},
+		work:  make(chan *loadBatch, workers),
+		order: make(chan *loadBatch, 2*workers),
+		stop:  make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers-1; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range p.work {
+				p.decode(b)
+			}
+		}()
+	}
+	var scanErr error
+	go func() {
+		scanErr = scanRecords(br, f, p, p.span)
+		if scanErr == nil || !errors.Is(scanErr, errStopped) {
+			// Pass on the objects before the end or the bad record.
+			if p.b.n > 0 {
+				p.flush()
+			}
+		}
+		close(p.work)
+		close(p.order)
+	}()
+
+	for b := range p.order {
+		if err != nil {
+			continue // drain
+		}
+		<-b.done
+		for i := range b.objs {
+			o := &b.objs[i]
+			if o.TypeAddr != 0 {
+				// As Scan checks.  Types preceding o are loaded.
+				if o.Type = l.d.TypeMap[o.TypeAddr]; o.Type == nil {
+					err = &FormatError{o.start, tagObject, errors.New("object type used before it appears")}
+					break
+				}
+			}
+			if e := l.object(o, b.keys[i]); e != nil {
+				err = &FormatError{o.start, tagObject, e}
+				break
+			}
+		}
+		if err == nil {
+			err = b.err
+		}
+		if err == nil && b.other != nil {
+			err = b.other()
+		}
+		if err != nil {
+			close(p.stop)
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return scanErr
+}
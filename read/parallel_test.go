@@ -0,0 +1,20 @@
+package read
+
+import "testing"
+
+// TestParallelLoad checks that loading in parallel builds the same
+// Dump as loading serially.
+func TestParallelLoad(t *testing.T) {
+	// Small batches and thresholds, so that the test dump is split.
+	defer func(b, m int) { loadBatchSize, minParallel = b, m }(loadBatchSize, minParallel)
+	loadBatchSize, minParallel = 7, 64
+
+	name := testDump(t)
+	serial := openTest(t, name, Parallelism(1))
+	if serial.NumObjects() < 10*loadBatchSize {
+		t.Fatalf("only %d objects in test dump", serial.NumObjects())
+	}
+	for _, n := range []int{2, 3, 8} {
+		compareDumps(t, serial, openTest(t, name, Parallelism(n)))
+	}
+}
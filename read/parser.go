@@ -7,14 +7,15 @@ import (
 	"debug/macho"
 	"debug/pe"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"os"
+	"math"
 	"regexp"
 	"runtime"
-	"sort"
+	"sync"
 )
 
 type FieldKind int
@@ -89,37 +90,83 @@ const (
 )
 
 type Dump struct {
-	Order        binary.ByteOrder
-	PtrSize      uint64 // in bytes
-	HChanSize    uint64 // channel header size in bytes
-	HeapStart    uint64
-	HeapEnd      uint64
-	TheChar      byte
-	Experiment   string
-	Ncpu         uint64
-	Types        []*Type
-	objects      []object
-	Frames       []*StackFrame
-	Goroutines   []*GoRoutine
-	Otherroots   []*OtherRoot
-	Finalizers   []*Finalizer  // pending finalizers, object still live
-	QFinal       []*QFinalizer // finalizers which are ready to run
-	Osthreads    []*OSThread
-	Memstats     *runtime.MemStats
-	Data         *Data
-	Bss          *Data
-	Defers       []*Defer
-	Panics       []*Panic
-	MemProf      []*MemProfEntry
-	AllocSamples []*AllocSample
+	Params
+	Types          []*Type
+	objects        objectTable
+	Frames         []*StackFrame
+	Goroutines     []*GoRoutine
+	Otherroots     []*OtherRoot
+	finalizerRoots []*OtherRoot  // see FinalizerRoots
+	Finalizers     []*Finalizer  // pending finalizers, object still live
+	QFinal         []*QFinalizer // finalizers which are ready to run
+	Osthreads      []*OSThread
+	Memstats       *runtime.MemStats
+	Data           *Data
+	Bss            *Data
+	Defers         []*Defer
+	Panics         []*Panic
+	MemProf        []*MemProfEntry
+	AllocSamples   []*AllocSample
 
 	// handle to dump file
 	r io.ReaderAt
 
+	// releases r, if we opened it
+	closer io.Closer
+
+	// the dump file mapped into memory, if using mmap
+	mapped []byte
+
+	// index file the dump is cached in, and the client sections
+	// loaded from it; see Cache
+	cachePath     string
+	cacheStamp    cacheStamp
+	cacheMu       sync.Mutex // guards cacheSections and the index file
+	cacheSections map[string][]byte
+
+	// outgoing edges of all the objects, from the index file: the
+	// edges of x are encoded in edgeData[edgeOff[x]:edgeOff[x+1]]
+	edgeOff  []int
+	edgeData []byte
+
+	// map from sampled objects to their allocation sites,
+	// built on first use
+	allocOnce  sync.Once
+	allocSites map[ObjId]*MemProfEntry
+
+	syms *symtab // symbols of the executable, or nil
+
+	stringLimit int // see StringLimit
+
+	// sizes of slice elements, by BaseType; see ElemSize
+	elemSizes map[string]uint64
+
+	// layouts of map types, by header type name; see Map
+	mapTypes   map[string]*mapType
+	mapOnce    sync.Once
+	mapHeaders map[ObjId]*mapType // built on first use
+
+	// channel header layout and channel types, by header type
+	// name; see Chan
+	hchan     *chanLayout
+	chanTypes map[string]*chanType
+	chanOnce  sync.Once
+	chanObjs  map[ObjId]*chanType // built on first use
+
+	// reverse edge index, built on first use; see Referrers
+	refOnce  sync.Once
+	refErr   error
+	refRoots []Root
+	refOff   []int    // referrers of x are refs[refOff[x]:refOff[x+1]]
+	refs     []uint32 // object ids, or NumObjects()+i for refRoots[i]
+
 	buf []byte // temporary space for Contents calls
 
 	edges []Edge // temporary space for Edges calls
 
+	// where to report non-fatal problems, nil to discard them
+	logger *log.Logger
+
 	// list of full types, indexed by ID
 	FTList []*FullType
 
@@ -135,7 +182,7 @@ type Dump struct {
 	// track of the lowest address object that has any of its
 	// bytes in that bucket.
 	bucketSize uint64
-	idx        []ObjId
+	idx        []uint32
 }
 
 type Type struct {
@@ -168,14 +215,6 @@ type Edge struct {
 	FieldName string
 }
 
-// object represents an object in the heap.
-// There will be a lot of these.  They need to be small.
-type object struct {
-	Ft     *FullType
-	offset int64 // position of object contents in dump file
-	Addr   uint64
-}
-
 type ObjId int
 
 const (
@@ -185,31 +224,71 @@ const (
 // NumObjects returns the number of objects in the heap.  Valid
 // ObjIds for other calls are from 0 to NumObjects()-1.
 func (d *Dump) NumObjects() int {
-	return len(d.objects)
+	return len(d.objects.addr)
 }
+
+// Contents returns the contents of object i.  The returned slice is
+// only valid until the next call to Contents.  If the dump is mapped
+// into memory (see Mmap), the slice refers to the mapping and must
+// not be modified.  It panics with an
+// *ObjectError if the contents can't be read from the dump file.
+// Contents may not be called concurrently; use ContentsInto instead.
 func (d *Dump) Contents(i ObjId) []byte {
-	x := d.objects[i]
-	b := d.buf
-	if uint64(cap(b)) < x.Ft.Size {
-		b = make([]byte, x.Ft.Size)
+	b, err := d.ReadContents(i)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ReadContents is like Contents but returns an error instead
+// of panicking.
+func (d *Dump) ReadContents(i ObjId) ([]byte, error) {
+	b, err := d.ContentsInto(i, d.buf)
+	if err != nil {
+		return nil, err
+	}
+	if d.mapped == nil {
 		d.buf = b
 	}
-	b = b[:x.Ft.Size]
-	n, err := d.r.ReadAt(b, x.offset)
+	return b, nil
+}
+
+// ContentsInto reads the contents of object i into buf, growing it
+// if needed, and returns the filled slice.  If the dump is mapped
+// into memory, buf is not used and the returned slice refers to the
+// mapping instead.  It is safe to call ContentsInto from multiple
+// goroutines with distinct buffers.
+func (d *Dump) ContentsInto(i ObjId, buf []byte) ([]byte, error) {
+	off := d.objects.offset[i]
+	size := d.Size(i)
+	if d.mapped != nil {
+		end := off + int64(size)
+		if end > int64(len(d.mapped)) {
+			return nil, &ObjectError{i, d.Addr(i), io.ErrUnexpectedEOF}
+		}
+		return d.mapped[off:end:end], nil
+	}
+	b := buf
+	if uint64(cap(b)) < size {
+		b = make([]byte, size)
+	}
+	b = b[:size]
+	n, err := d.r.ReadAt(b, off)
 	if err != nil && !(n == len(b) && err == io.EOF) {
-		// TODO: propagate to caller
-		log.Fatal(err)
+		return nil, &ObjectError{i, d.Addr(i), err}
 	}
-	return b
+	return b, nil
 }
+
 func (d *Dump) Addr(x ObjId) uint64 {
-	return d.objects[x].Addr
+	return d.objects.addr[x]
 }
 func (d *Dump) Size(x ObjId) uint64 {
-	return d.objects[x].Ft.Size
+	return d.Ft(x).Size
 }
 func (d *Dump) Ft(x ObjId) *FullType {
-	return d.objects[x].Ft
+	return d.FTList[d.objects.ft[x]]
 }
 
 // FindObj returns the object id containing the address addr, or -1 if no object contains addr.
@@ -218,42 +297,97 @@ func (d *Dump) FindObj(addr uint64) ObjId {
 		return ObjNil
 	}
 	// linear search among all the objects that map to the same bucketSize-byte bucket.
-	for i := d.idx[(addr-d.HeapStart)/bucketSize]; i < ObjId(len(d.objects)); i++ {
-		x := &d.objects[i]
-		if addr < x.Addr {
+	n := ObjId(d.NumObjects())
+	for i := ObjId(d.idx[(addr-d.HeapStart)/bucketSize]); i < n; i++ {
+		a := d.objects.addr[i]
+		if addr < a {
 			return ObjNil
 		}
-		if addr < x.Addr+x.Ft.Size {
-			return ObjId(i)
+		if addr < a+d.Size(i) {
+			return i
 		}
 	}
 	return ObjNil
 }
 
+// Edges returns the outgoing edges of object i.  The returned slice
+// is only valid until the next call to Edges.  It panics with an
+// *ObjectError if the object can't be decoded.  Edges may not be
+// called concurrently; use EdgesInto instead.
 func (d *Dump) Edges(i ObjId) []Edge {
-	x := &d.objects[i]
-	e := d.edges[:0]
-	b := d.Contents(i)
-	for _, f := range x.Ft.Fields {
+	e, err := d.ReadEdges(i)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// ReadEdges is like Edges but returns an error instead of panicking.
+func (d *Dump) ReadEdges(i ObjId) ([]Edge, error) {
+	if d.edgeOff != nil {
+		d.edges = d.cachedEdges(i, d.edges[:0])
+		return d.edges, nil
+	}
+	b, err := d.ReadContents(i)
+	if err != nil {
+		return nil, err
+	}
+	e, err := d.objEdges(i, b, d.edges[:0])
+	if err != nil {
+		return nil, err
+	}
+	d.edges = e
+	return e, nil
+}
+
+// EdgesInto appends the outgoing edges of object i to dst and
+// returns the extended slice.  It is safe to call EdgesInto from
+// multiple goroutines with distinct dst slices.
+func (d *Dump) EdgesInto(i ObjId, dst []Edge) ([]Edge, error) {
+	if d.edgeOff != nil {
+		return d.cachedEdges(i, dst), nil
+	}
+	bp := contentsPool.Get().(*[]byte)
+	defer contentsPool.Put(bp)
+	b, err := d.ContentsInto(i, *bp)
+	if err != nil {
+		return dst, err
+	}
+	if d.mapped == nil {
+		// Never pool a slice of the mapping: it is read-only, and
+		// goes away when the dump is closed.
+		*bp = b
+	}
+	return d.objEdges(i, b, dst)
+}
+
+// scratch space for EdgesInto
+var contentsPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// objEdges appends to e the edges found in b, the contents of object i.
+func (d *Dump) objEdges(i ObjId, b []byte, e []Edge) ([]Edge, error) {
+	for _, f := range d.Ft(i).Fields {
 		switch f.Kind {
 		case FieldKindPtr, FieldKindString, FieldKindSlice:
 			p := readPtr(d, b[f.Offset:])
 			y := d.FindObj(p)
 			if y != ObjNil {
-				e = append(e, Edge{y, f.Offset, p - d.objects[y].Addr, f.Name})
+				e = append(e, Edge{y, f.Offset, p - d.Addr(y), f.Name})
 			}
 		case FieldKindEface:
 			taddr := readPtr(d, b[f.Offset:])
 			if taddr != 0 {
 				t := d.TypeMap[taddr]
 				if t == nil {
-					log.Fatal("can't find eface type", taddr)
+					return e, &ObjectError{i, d.Addr(i), fmt.Errorf("can't find eface type %x", taddr)}
 				}
 				if t.efaceptr {
 					p := readPtr(d, b[f.Offset+d.PtrSize:])
 					y := d.FindObj(p)
 					if y != ObjNil {
-						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name})
+						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.Addr(y), f.Name})
 					}
 				}
 			}
@@ -262,13 +396,13 @@ func (d *Dump) Edges(i ObjId) []Edge {
 			if itabaddr != 0 {
 				ptr, ok := d.ItabMap[itabaddr]
 				if !ok {
-					log.Fatal("can't find itab", itabaddr)
+					return e, &ObjectError{i, d.Addr(i), fmt.Errorf("can't find itab %x", itabaddr)}
 				}
 				if ptr {
 					p := readPtr(d, b[f.Offset+d.PtrSize:])
 					y := d.FindObj(p)
 					if y != ObjNil {
-						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name})
+						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.Addr(y), f.Name})
 					}
 				}
 			}
@@ -276,8 +410,7 @@ func (d *Dump) Edges(i ObjId) []Edge {
 			continue
 		}
 	}
-	d.edges = e
-	return e
+	return e, nil
 }
 
 type OtherRoot struct {
@@ -287,56 +420,71 @@ type OtherRoot struct {
 	toaddr uint64
 }
 
-// Object obj has a finalizer.
+// Object ObjAddr has a finalizer.
 type Finalizer struct {
-	obj  uint64
-	fn   uint64 // function to be run (a FuncVal*)
-	code uint64 // code ptr (fn->fn)
-	fint uint64 // type of function argument
-	ot   uint64 // type of object
+	ObjAddr  uint64
+	FnAddr   uint64 // function to be run (a FuncVal*)
+	Code     uint64 // code ptr (fn->fn)
+	FintAddr uint64 // type of function argument
+	OtAddr   uint64 // type of object
+	Edges    []Edge // to the object and the FuncVal, if in the heap
 }
 
 // Finalizer that's ready to run
 type QFinalizer struct {
-	obj   uint64
-	fn    uint64 // function to be run (a FuncVal*)
-	code  uint64 // code ptr (fn->fn)
-	fint  uint64 // type of function argument
-	ot    uint64 // type of object
-	Edges []Edge
+	ObjAddr  uint64
+	FnAddr   uint64 // function to be run (a FuncVal*)
+	Code     uint64 // code ptr (fn->fn)
+	FintAddr uint64 // type of function argument
+	OtAddr   uint64 // type of object
+	Edges    []Edge
 }
 
+// A pending defer call.
 type Defer struct {
-	addr uint64
+	Addr      uint64
+	Goroutine *GoRoutine // goroutine that deferred the call
+	Argp      uint64     // argument pointer of the deferring frame
+	Pc        uint64     // pc of the defer statement
+	Fn        ObjId      // heap object holding the closure, or ObjNil
+	FnAddr    uint64     // function to be run (a FuncVal*)
+	Code      uint64     // code ptr (fn->fn)
+	Link      *Defer     // next defer on the goroutine's list
+
 	gp   uint64
-	argp uint64
-	pc   uint64
-	fn   uint64
-	code uint64
 	link uint64
 }
 
+// An in-flight panic.
 type Panic struct {
-	addr uint64
+	Addr      uint64
+	Goroutine *GoRoutine // panicking goroutine
+	Typ       uint64     // type of the panic argument
+	Data      ObjId      // heap object holding the panic argument, or ObjNil
+	DataAddr  uint64     // data word of the panic argument
+	Defer     *Defer     // defer being run when the panic happened
+	Link      *Panic     // next panic on the goroutine's list
+
 	gp   uint64
-	typ  uint64
-	data uint64
 	defr uint64
 	link uint64
 }
 
+// A MemProfFrame is one call in the stack of an allocation site.
 type MemProfFrame struct {
 	Func string
 	File string
 	Line uint64
 }
 
+// A MemProfEntry is a memory profile bucket: the allocations of
+// objects of one size from one call stack.
 type MemProfEntry struct {
-	addr   uint64
-	size   uint64
-	stack  []MemProfFrame
-	allocs uint64
-	frees  uint64
+	Addr   uint64         // identifies the bucket in the dump
+	Size   uint64         // size of each object allocated
+	Stack  []MemProfFrame // innermost call first
+	Allocs uint64         // number of objects allocated
+	Frees  uint64         // number of those objects freed
 }
 
 type AllocSample struct {
@@ -352,9 +500,9 @@ type Data struct {
 }
 
 type OSThread struct {
-	addr   uint64
-	id     uint64
-	procid uint64
+	Addr   uint64 // address of the runtime's M
+	Id     uint64 // runtime's id for the M
+	ProcId uint64 // operating system's id for the thread
 }
 
 // A Field is a location in an object where there
@@ -366,14 +514,31 @@ type Field struct {
 	BaseType string // base type for Ptr, Slice, Iface ("" if not known)
 }
 
+// ElemSize returns the size of the elements of the slice field f.
+// It reports false if the size is unknown, because f is not a slice
+// or the dump was read without an executable.
+func (d *Dump) ElemSize(f Field) (uint64, bool) {
+	if f.Kind != FieldKindSlice {
+		return 0, false
+	}
+	n, ok := d.elemSizes[f.BaseType]
+	return n, ok
+}
+
 type GoRoutine struct {
-	Bos  *StackFrame // frame at the top of the stack (i.e. currently running)
-	Ctxt ObjId
+	Bos    *StackFrame // frame at the top of the stack (i.e. currently running)
+	Ctxt   ObjId
+	Defers []*Defer  // pending defers, most recent first
+	Panics []*Panic  // in-flight panics, most recent first
+	Thread *OSThread // thread running the goroutine, or nil
 
 	Addr         uint64
 	bosaddr      uint64
 	Goid         uint64
-	Gopc         uint64
+	Gopc         uint64 // pc of the go statement that created the goroutine
+	CreatedBy    string // function containing Gopc, if the executable is known
+	CreatedFile  string
+	CreatedLine  int
 	Status       uint64
 	IsSystem     bool
 	IsBackground bool
@@ -385,6 +550,34 @@ type GoRoutine struct {
 	panicaddr    uint64
 }
 
+// State returns a description of the state of g: its wait reason if
+// it is waiting, or its scheduling state.
+func (g *GoRoutine) State() string {
+	// Later runtimes may report a goroutine being scanned
+	// by the garbage collector.  Ignore that bit.
+	switch g.Status &^ 0x1000 {
+	case 0:
+		return "idle"
+	case 1:
+		return "runnable"
+	case 2:
+		// Only the goroutine writing the dump.
+		return "running"
+	case 3:
+		return "syscall"
+	case 4:
+		return g.WaitReason
+	case 5, 6:
+		return "dead"
+	case 8:
+		return "copystack"
+	case 9:
+		return "preempted"
+	default:
+		return fmt.Sprintf("status %d", g.Status)
+	}
+}
+
 type StackFrame struct {
 	Name      string
 	Parent    *StackFrame
@@ -392,70 +585,118 @@ type StackFrame struct {
 	Depth     uint64
 	Data      []byte
 	Edges     []Edge
+	File      string // source position, if the executable is known
+	Line      int
 
 	Addr      uint64
 	childaddr uint64
-	entry     uint64
-	pc        uint64
+	Entry     uint64 // pc of function entry
+	PC        uint64 // pc the frame is suspended at
 	Fields    []Field
 }
 
-// both an io.Reader and an io.ByteReader
-type Reader interface {
-	Read(p []byte) (n int, err error)
-	ReadByte() (c byte, err error)
-}
+// The read* functions below never fail.  Instead, the first error is
+// recorded in r and all subsequent reads return zero values.  Callers
+// check r.err once they have read a whole record.
 
-func readUint64(r Reader) uint64 {
+func readUint64(r *myReader) uint64 {
+	if r.err != nil {
+		return 0
+	}
 	x, err := binary.ReadUvarint(r)
 	if err != nil {
-		log.Fatal(err)
+		r.fail(err)
+		return 0
 	}
 	return x
 }
 
-func readNBytes(r Reader, n uint64) []byte {
-	s := make([]byte, n)
-	_, err := io.ReadFull(r, s)
+func readNBytes(r *myReader, n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	// Don't trust n to preallocate - a corrupt length would
+	// have us allocate an arbitrary amount of memory.
+	s, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && uint64(len(s)) != n {
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
-		log.Fatal(err)
+		r.fail(err)
+		return nil
 	}
 	return s
 }
 
-func readBytes(r Reader) []byte {
+func readBytes(r *myReader) []byte {
 	n := readUint64(r)
 	return readNBytes(r, n)
 }
 
-func readString(r Reader) string {
+func readString(r *myReader) string {
 	return string(readBytes(r))
 }
 
-func readBool(r Reader) bool {
+func readBool(r *myReader) bool {
+	if r.err != nil {
+		return false
+	}
 	b, err := r.ReadByte()
 	if err != nil {
-		log.Fatal(err)
+		r.fail(err)
+		return false
 	}
 	return b != 0
 }
 
-func readFields(r Reader) []Field {
-	var x []Field
-	for {
-		kind := FieldKind(readUint64(r))
-		if kind == FieldKindEol {
-			// TODO: sort by offset, or check that it is sorted
-			return x
-		}
-		x = append(x, Field{Kind: kind, Offset: readUint64(r)})
+// A Reader that can tell you its current offset in the file.
+type myReader struct {
+	r    *bufio.Reader
+	cnt  int64
+	err  error // first error encountered
+	size int64 // total size of the input, if known
+
+	// If at is not nil, r reads from it, and Skip jumps over
+	// bytes instead of reading them.
+	at *atReader
+}
+
+// newReaderAt returns a myReader reading r from off up to end.
+func newReaderAt(r io.ReaderAt, off, end int64) *myReader {
+	at := &atReader{r, off, end}
+	return &myReader{r: bufio.NewReader(at), cnt: off, at: at}
+}
+
+// An atReader reads an io.ReaderAt sequentially from off up to end.
+type atReader struct {
+	r        io.ReaderAt
+	off, end int64
+}
+
+func (r *atReader) Read(p []byte) (int, error) {
+	if r.off >= r.end {
+		return 0, io.EOF
+	}
+	if n := r.end - r.off; int64(len(p)) > n {
+		p = p[:n]
+	}
+	n, err := r.r.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
 	}
+	return n, err
 }
 
-// A Reader that can tell you its current offset in the file.
-type myReader struct {
-	r   *bufio.Reader
-	cnt int64
+// fail records err as the reason decoding stopped.
+func (r *myReader) fail(err error) {
+	if err == io.EOF {
+		// We only read when we expect more data.
+		err = io.ErrUnexpectedEOF
+	}
+	if r.err == nil {
+		r.err = err
+	}
 }
 
 func (r *myReader) Read(p []byte) (n int, err error) {
@@ -476,10 +717,31 @@ func (r *myReader) ReadLine() (line []byte, isPrefix bool, err error) {
 	r.cnt += int64(len(line)) + 1
 	return
 }
-func (r *myReader) Skip(n int64) error {
+func (r *myReader) Skip(n int64) {
+	if r.err != nil {
+		return
+	}
+	if n < 0 {
+		// A size too big for the file.
+		r.fail(io.ErrUnexpectedEOF)
+		return
+	}
+	if r.at != nil && n > int64(r.r.Buffered()) {
+		if n > r.at.end-r.cnt {
+			r.cnt = r.at.end
+			r.fail(io.ErrUnexpectedEOF)
+			return
+		}
+		r.cnt += n
+		r.at.off = r.cnt
+		r.r.Reset(r.at)
+		return
+	}
 	k, err := io.CopyN(ioutil.Discard, r.r, n)
 	r.cnt += k
-	return err
+	if err != nil {
+		r.fail(err)
+	}
 }
 func (r *myReader) Count() int64 {
 	return r.cnt
@@ -489,12 +751,18 @@ type tkey struct {
 	typaddr uint64
 	kind    TypeKind
 	size    uint64
+	layout  string // see layoutKey, for objects without types
 }
 
-func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullType {
+// makeFullType returns a new full type.  fields is the pointer layout
+// recorded with the object in formats that don't record object types.
+func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64, fields []Field) (*FullType, error) {
 	t := d.TypeMap[typaddr]
 	if typaddr != 0 && t == nil {
-		log.Fatal("types appear before use of that type")
+		return nil, fmt.Errorf("type %x used before it appears", typaddr)
+	}
+	if kind != TypeKindObject && kind != TypeKindConservative && t == nil {
+		return nil, fmt.Errorf("object kind %d requires a type", kind)
 	}
 	var name string
 	switch kind {
@@ -502,13 +770,16 @@ func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullTyp
 		if t != nil {
 			name = t.Name
 		} else {
-			name = fmt.Sprintf("noptr%d", size)
+			name = layoutName(size, fields)
 		}
 	case TypeKindArray:
+		if t.Size == 0 {
+			return nil, fmt.Errorf("array of zero-sized type %s", t.Name)
+		}
 		name = fmt.Sprintf("{%d}%s", size/t.Size, t.Name)
 	case TypeKindChan:
 		if d.HChanSize == 0 {
-			log.Fatal("hchansize must be before objects")
+			return nil, errors.New("hchansize must be before objects")
 		}
 		if t.Size > 0 {
 			name = fmt.Sprintf("chan{%d}%s", (size-d.HChanSize)/t.Size, t.Name)
@@ -517,239 +788,135 @@ func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullTyp
 		}
 	case TypeKindConservative:
 		name = fmt.Sprintf("conservative%d", size)
+	default:
+		return nil, fmt.Errorf("unknown object kind %d", kind)
 	}
-	ft := &FullType{len(d.FTList), t, kind, size, name, nil}
+	ft := &FullType{len(d.FTList), t, kind, size, name, fields}
 	d.FTList = append(d.FTList, ft)
-	return ft
+	return ft, nil
 }
 
-// Reads heap dump into memory.
-func rawRead(filename string) *Dump {
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
+// Reads heap dump into memory.  Object contents stay in r.
+func rawRead(r io.ReaderAt, size int64, workers int) (*Dump, error) {
+	d := &Dump{}
+	d.r = r
+	d.ItabMap = map[uint64]bool{}
+	d.TypeMap = map[uint64]*Type{}
+	l := &loader{d: d, ftmap: map[tkey]*FullType{}}
+	var err error
+	if workers > 1 {
+		err = scanParallel(r, size, l, workers)
+	} else {
+		err = scanAt(r, size, l)
 	}
-	r := &myReader{r: bufio.NewReader(file)}
-
-	// check for header
-	hdr, prefix, err := r.ReadLine()
 	if err != nil {
-		log.Fatal(err)
-	}
-	if prefix || string(hdr) != "go1.3 heap dump" {
-		log.Fatal("not a go1.3 heap dump file")
+		return nil, err
 	}
+	d.objects.trim()
+	return d, nil
+}
 
-	var d Dump
-	d.r = file
-	d.ItabMap = map[uint64]bool{}
-	d.TypeMap = map[uint64]*Type{}
-	ftmap := map[tkey]*FullType{} // full type dedup
-	memprof := map[uint64]*MemProfEntry{}
-	for {
-		kind := readUint64(r)
-		switch kind {
-		case tagObject:
-			obj := object{}
-			obj.Addr = readUint64(r)
-			typaddr := readUint64(r)
-			kind := TypeKind(readUint64(r))
-			size := readUint64(r)
-			k := tkey{typaddr, kind, size}
-			ft := ftmap[k]
-			if ft == nil {
-				ft = d.makeFullType(typaddr, kind, size)
-				ftmap[k] = ft
-			}
-			obj.Ft = ft
-			obj.offset = r.Count()
-			r.Skip(int64(ft.Size))
-			d.objects = append(d.objects, obj)
-		case tagEOF:
-			return &d
-		case tagOtherRoot:
-			t := &OtherRoot{}
-			t.Description = readString(r)
-			t.toaddr = readUint64(r)
-			d.Otherroots = append(d.Otherroots, t)
-		case tagType:
-			typ := &Type{}
-			typ.Addr = readUint64(r)
-			typ.Size = readUint64(r)
-			typ.Name = readString(r)
-			typ.efaceptr = readBool(r)
-			typ.Fields = readFields(r)
-			// Note: there may be duplicate type records in a dump.
-			// The duplicates get thrown away here.
-			if _, ok := d.TypeMap[typ.Addr]; !ok {
-				d.TypeMap[typ.Addr] = typ
-				d.Types = append(d.Types, typ)
-			}
-		case tagGoRoutine:
-			g := &GoRoutine{}
-			g.Addr = readUint64(r)
-			g.bosaddr = readUint64(r)
-			g.Goid = readUint64(r)
-			g.Gopc = readUint64(r)
-			g.Status = readUint64(r)
-			g.IsSystem = readBool(r)
-			g.IsBackground = readBool(r)
-			g.WaitSince = readUint64(r)
-			g.WaitReason = readString(r)
-			g.ctxtaddr = readUint64(r)
-			g.maddr = readUint64(r)
-			g.deferaddr = readUint64(r)
-			g.panicaddr = readUint64(r)
-			d.Goroutines = append(d.Goroutines, g)
-		case tagStackFrame:
-			t := &StackFrame{}
-			t.Addr = readUint64(r)
-			t.Depth = readUint64(r)
-			t.childaddr = readUint64(r)
-			t.Data = readBytes(r)
-			t.entry = readUint64(r)
-			t.pc = readUint64(r)
-			readUint64(r) // continpc
-			t.Name = readString(r)
-			t.Fields = readFields(r)
-			d.Frames = append(d.Frames, t)
-		case tagParams:
-			if readUint64(r) == 0 {
-				d.Order = binary.LittleEndian
-			} else {
-				d.Order = binary.BigEndian
-			}
-			d.PtrSize = readUint64(r)
-			d.HChanSize = readUint64(r)
-			d.HeapStart = readUint64(r)
-			d.HeapEnd = readUint64(r)
-			d.TheChar = byte(readUint64(r))
-			d.Experiment = readString(r)
-			d.Ncpu = readUint64(r)
-		case tagFinalizer:
-			t := &Finalizer{}
-			t.obj = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
-			t.fint = readUint64(r)
-			t.ot = readUint64(r)
-			d.Finalizers = append(d.Finalizers, t)
-		case tagQFinal:
-			t := &QFinalizer{}
-			t.obj = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
-			t.fint = readUint64(r)
-			t.ot = readUint64(r)
-			d.QFinal = append(d.QFinal, t)
-		case tagData:
-			t := &Data{}
-			t.Addr = readUint64(r)
-			t.Data = readBytes(r)
-			t.Fields = readFields(r)
-			d.Data = t
-		case tagBss:
-			t := &Data{}
-			t.Addr = readUint64(r)
-			t.Data = readBytes(r)
-			t.Fields = readFields(r)
-			d.Bss = t
-		case tagItab:
-			addr := readUint64(r)
-			ptr := readBool(r)
-			d.ItabMap[addr] = ptr
-		case tagOSThread:
-			t := &OSThread{}
-			t.addr = readUint64(r)
-			t.id = readUint64(r)
-			t.procid = readUint64(r)
-			d.Osthreads = append(d.Osthreads, t)
-		case tagMemStats:
-			t := &runtime.MemStats{}
-			t.Alloc = readUint64(r)
-			t.TotalAlloc = readUint64(r)
-			t.Sys = readUint64(r)
-			t.Lookups = readUint64(r)
-			t.Mallocs = readUint64(r)
-			t.Frees = readUint64(r)
-			t.HeapAlloc = readUint64(r)
-			t.HeapSys = readUint64(r)
-			t.HeapIdle = readUint64(r)
-			t.HeapInuse = readUint64(r)
-			t.HeapReleased = readUint64(r)
-			t.HeapObjects = readUint64(r)
-			t.StackInuse = readUint64(r)
-			t.StackSys = readUint64(r)
-			t.MSpanInuse = readUint64(r)
-			t.MSpanSys = readUint64(r)
-			t.MCacheInuse = readUint64(r)
-			t.MCacheSys = readUint64(r)
-			t.BuckHashSys = readUint64(r)
-			t.GCSys = readUint64(r)
-			t.OtherSys = readUint64(r)
-			t.NextGC = readUint64(r)
-			t.LastGC = readUint64(r)
-			t.PauseTotalNs = readUint64(r)
-			for i := 0; i < 256; i++ {
-				t.PauseNs[i] = readUint64(r)
-			}
-			t.NumGC = uint32(readUint64(r))
-			d.Memstats = t
-		case tagDefer:
-			t := &Defer{}
-			t.addr = readUint64(r)
-			t.gp = readUint64(r)
-			t.argp = readUint64(r)
-			t.pc = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
-			t.link = readUint64(r)
-			d.Defers = append(d.Defers, t)
-		case tagPanic:
-			t := &Panic{}
-			t.addr = readUint64(r)
-			t.gp = readUint64(r)
-			t.typ = readUint64(r)
-			t.data = readUint64(r)
-			t.defr = readUint64(r)
-			t.link = readUint64(r)
-			d.Panics = append(d.Panics, t)
-		case tagMemProf:
-			t := &MemProfEntry{}
-			key := readUint64(r)
-			t.size = readUint64(r)
-			nstk := readUint64(r)
-			for i := uint64(0); i < nstk; i++ {
-				fn := readString(r)
-				file := readString(r)
-				line := readUint64(r)
-				// TODO: intern fn, file.  They will repeat a lot.
-				t.stack = append(t.stack, MemProfFrame{fn, file, line})
-			}
-			t.allocs = readUint64(r)
-			t.frees = readUint64(r)
-			d.MemProf = append(d.MemProf, t)
-			memprof[key] = t
-		case tagAllocSample:
-			t := &AllocSample{}
-			t.Addr = readUint64(r)
-			t.Prof = memprof[readUint64(r)]
-			d.AllocSamples = append(d.AllocSamples, t)
-		default:
-			log.Fatal("unknown record kind ", kind)
+// loader is the Visitor that builds a Dump.
+type loader struct {
+	d     *Dump
+	ftmap map[tkey]*FullType // full type dedup
+}
+
+func (l *loader) Params(p *Params) error {
+	l.d.Params = *p
+	return nil
+}
+func (l *loader) Type(t *Type) error {
+	l.d.TypeMap[t.Addr] = t
+	l.d.Types = append(l.d.Types, t)
+	return nil
+}
+func (l *loader) Object(o *ObjectRecord) error {
+	return l.object(o, objKey(o))
+}
+
+// objKey returns the key identifying the full type of o.
+func objKey(o *ObjectRecord) tkey {
+	return tkey{o.TypeAddr, o.Kind, o.Size, layoutKey(o.Fields)}
+}
+
+func (l *loader) object(o *ObjectRecord, k tkey) error {
+	ft := l.ftmap[k]
+	if ft == nil {
+		var err error
+		ft, err = l.d.makeFullType(o.TypeAddr, o.Kind, o.Size, o.Fields)
+		if err != nil {
+			return err
 		}
+		l.ftmap[k] = ft
 	}
-	// TODO: any easy way to truncate the objects array?  We could
-	// reclaim the fraction that append() added but we didn't need.
+	l.d.objects.add(ft, o.Offset, o.Addr)
+	return nil
+}
+func (l *loader) Itab(addr uint64, ptr bool) error {
+	l.d.ItabMap[addr] = ptr
+	return nil
+}
+func (l *loader) GoRoutine(g *GoRoutine) error {
+	l.d.Goroutines = append(l.d.Goroutines, g)
+	return nil
+}
+func (l *loader) StackFrame(f *StackFrame) error {
+	l.d.Frames = append(l.d.Frames, f)
+	return nil
+}
+func (l *loader) OtherRoot(r *OtherRoot) error {
+	l.d.Otherroots = append(l.d.Otherroots, r)
+	return nil
+}
+func (l *loader) Finalizer(f *Finalizer) error {
+	l.d.Finalizers = append(l.d.Finalizers, f)
+	return nil
+}
+func (l *loader) QFinalizer(f *QFinalizer) error {
+	l.d.QFinal = append(l.d.QFinal, f)
+	return nil
+}
+func (l *loader) Data(x *Data) error {
+	l.d.Data = x
+	return nil
+}
+func (l *loader) Bss(x *Data) error {
+	l.d.Bss = x
+	return nil
+}
+func (l *loader) OSThread(t *OSThread) error {
+	l.d.Osthreads = append(l.d.Osthreads, t)
+	return nil
+}
+func (l *loader) MemStats(m *runtime.MemStats) error {
+	l.d.Memstats = m
+	return nil
+}
+func (l *loader) Defer(x *Defer) error {
+	l.d.Defers = append(l.d.Defers, x)
+	return nil
+}
+func (l *loader) Panic(x *Panic) error {
+	l.d.Panics = append(l.d.Panics, x)
+	return nil
+}
+func (l *loader) MemProf(e *MemProfEntry) error {
+	l.d.MemProf = append(l.d.MemProf, e)
+	return nil
+}
+func (l *loader) AllocSample(s *AllocSample) error {
+	l.d.AllocSamples = append(l.d.AllocSamples, s)
+	return nil
 }
 
-func getDwarf(execname string) *dwarf.Data {
+func getDwarf(execname string) (*dwarf.Data, error) {
 	e, err := elf.Open(execname)
 	if err == nil {
 		defer e.Close()
 		d, err := e.DWARF()
 		if err == nil {
-			return d
+			return d, nil
 		}
 	}
 	m, err := macho.Open(execname)
@@ -757,7 +924,7 @@ func getDwarf(execname string) *dwarf.Data {
 		defer m.Close()
 		d, err := m.DWARF()
 		if err == nil {
-			return d
+			return d, nil
 		}
 	}
 	p, err := pe.Open(execname)
@@ -765,11 +932,10 @@ func getDwarf(execname string) *dwarf.Data {
 		defer p.Close()
 		d, err := p.DWARF()
 		if err == nil {
-			return d
+			return d, nil
 		}
 	}
-	log.Fatal("can't get dwarf info from executable", err)
-	return nil
+	return nil, fmt.Errorf("can't get dwarf info from executable %s: %v", execname, err)
 }
 
 func readUleb(b []byte) ([]byte, uint64) {
@@ -884,7 +1050,8 @@ func (t *dwarfBaseType) Fields() []Field {
 	case t.encoding == dw_ate_complex_float && t.size == 16:
 		t.fields = append(t.fields, Field{FieldKindComplex128, 0, "", ""})
 	default:
-		log.Fatalf("unknown encoding type encoding=%d size=%d", t.encoding, t.size)
+		// Not a Go base type (e.g. a C long double).  We can't
+		// describe it, so leave it without fields.
 	}
 	return t.fields
 }
@@ -940,7 +1107,11 @@ func (t *dwarfStructType) Fields() []Field {
 			_, aok := t.members[0].type_.(*dwarfPtrType)
 			l, lok := t.members[1].type_.(*dwarfBaseType)
 			c, cok := t.members[2].type_.(*dwarfBaseType)
-			if aok && lok && cok && l.encoding == dw_ate_unsigned && c.encoding == dw_ate_unsigned {
+			// Older compilers described len and cap as unsigned.
+			integer := func(t *dwarfBaseType) bool {
+				return t.encoding == dw_ate_signed || t.encoding == dw_ate_unsigned
+			}
+			if aok && lok && cok && integer(l) && integer(c) {
 				t.fields = append(t.fields, Field{FieldKindSlice, 0, "", t.members[0].type_.Name()[1:]})
 				break
 			}
@@ -958,6 +1129,9 @@ func (t *dwarfArrayType) Fields() []Field {
 	if t.fields != nil {
 		return t.fields
 	}
+	if t.elem == nil {
+		return t.fields
+	}
 	s := t.elem.Size()
 	if s == 0 {
 		return t.fields
@@ -986,8 +1160,23 @@ var adjTypeNames = []adjTypeName{
 	{regexp.MustCompile(`bucket<(.*),(.*)>`), "map.bucket[%s]%s"},
 }
 
+// adjustTypeName maps a Dwarf type name to the name of the type
+// in the binary.
+func adjustTypeName(name string) string {
+	for _, a := range adjTypeNames {
+		if k := a.matcher.FindStringSubmatch(name); k != nil {
+			var i []interface{}
+			for _, j := range k[1:] {
+				i = append(i, j)
+			}
+			name = fmt.Sprintf(a.formatter, i...)
+		}
+	}
+	return name
+}
+
 // load a map of all of the dwarf types
-func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
+func typeMap(d *Dump, w *dwarf.Data) (map[dwarf.Offset]dwarfType, error) {
 	t := make(map[dwarf.Offset]dwarfType)
 
 	// pass 1: make a dwarfType for all of the types in the file
@@ -995,7 +1184,7 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		if e == nil {
 			break
@@ -1020,15 +1209,7 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 			x := new(dwarfStructType)
 			x.name = e.Val(dwarf.AttrName).(string)
 			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
-			for _, a := range adjTypeNames {
-				if k := a.matcher.FindStringSubmatch(x.name); k != nil {
-					var i []interface{}
-					for _, j := range k[1:] {
-						i = append(i, j)
-					}
-					x.name = fmt.Sprintf(a.formatter, i...)
-				}
-			}
+			x.name = adjustTypeName(x.name)
 			t[e.Offset] = x
 		case dwarf.TagArrayType:
 			x := new(dwarfArrayType)
@@ -1053,44 +1234,66 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		if e == nil {
 			break
 		}
+		// Entries without a name were skipped in pass 1, so the
+		// lookups in t below may fail.
 		switch e.Tag {
 		case dwarf.TagTypedef:
-			t[e.Offset].(*dwarfTypedef).type_ = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			if t[e.Offset].(*dwarfTypedef).type_ == nil {
-				log.Fatalf("can't find referent for %s %d\n", t[e.Offset].(*dwarfTypedef).name, e.Val(dwarf.AttrType).(dwarf.Offset))
+			x, ok := t[e.Offset].(*dwarfTypedef)
+			if !ok {
+				break
+			}
+			ref, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+			x.type_ = t[ref]
+			if x.type_ == nil {
+				return nil, fmt.Errorf("can't find referent for %s %d", x.name, ref)
 			}
 		case dwarf.TagPointerType:
-			i := e.Val(dwarf.AttrType)
-			if i != nil {
-				t[e.Offset].(*dwarfPtrType).elem = t[i.(dwarf.Offset)]
+			x, ok := t[e.Offset].(*dwarfPtrType)
+			if !ok {
+				break
+			}
+			if i, ok := e.Val(dwarf.AttrType).(dwarf.Offset); ok {
+				x.elem = t[i]
 			}
 			// The only nil cases are unsafe.Pointer and reflect.iword
 		case dwarf.TagArrayType:
-			t[e.Offset].(*dwarfArrayType).elem = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+			x, ok := t[e.Offset].(*dwarfArrayType)
+			if !ok {
+				break
+			}
+			ref, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+			x.elem = t[ref]
 		case dwarf.TagStructType:
-			currentStruct = t[e.Offset].(*dwarfStructType)
+			currentStruct, _ = t[e.Offset].(*dwarfStructType)
 		case dwarf.TagMember:
-			name := e.Val(dwarf.AttrName).(string)
-			type_ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrDataMemberLoc).([]uint8)
+			name, _ := e.Val(dwarf.AttrName).(string)
+			ref, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+			type_ := t[ref]
+			if currentStruct == nil || type_ == nil {
+				break
+			}
 			var offset uint64
-			if len(loc) == 0 {
-				offset = 0
-			} else if len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
-				loc, offset = readUleb(loc[1 : len(loc)-1])
-				if len(loc) != 0 {
-					break
+			switch loc := e.Val(dwarf.AttrDataMemberLoc).(type) {
+			case int64:
+				// Newer toolchains use a constant offset.
+				offset = uint64(loc)
+			case []uint8:
+				if len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
+					loc, offset = readUleb(loc[1 : len(loc)-1])
+					if len(loc) != 0 {
+						continue
+					}
 				}
 			}
 			currentStruct.members = append(currentStruct.members, dwarfTypeMember{name, offset, type_})
 		}
 	}
-	return t
+	return t, nil
 }
 
 type localKey struct {
@@ -1098,27 +1301,29 @@ type localKey struct {
 	offset   uint64 // distance down from frame pointer
 }
 
-// Makes a map from <function name, distance before top of frame> to name of field.
-func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]string {
-	m := make(map[localKey]string, 0)
+// Makes a map from <function name, distance before top of frame> to field.
+func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) (map[localKey]Field, error) {
+	m := make(map[localKey]Field, 0)
 	r := w.Reader()
 	var funcname string
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		if e == nil {
 			break
 		}
 		switch e.Tag {
 		case dwarf.TagSubprogram:
-			funcname = e.Val(dwarf.AttrName).(string)
+			funcname, _ = e.Val(dwarf.AttrName).(string)
 		case dwarf.TagVariable:
-			name := e.Val(dwarf.AttrName).(string)
-			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
-			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
+			name, _ := e.Val(dwarf.AttrName).(string)
+			ref, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+			typ := t[ref]
+			// Newer toolchains may use a location list here instead.
+			loc, _ := e.Val(dwarf.AttrLocation).([]uint8)
+			if typ == nil || len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
 				break
 			}
 			var offset int64
@@ -1131,37 +1336,39 @@ func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKe
 				}
 			}
 			for _, f := range typ.Fields() {
-				m[localKey{funcname, uint64(-offset) - f.Offset}] = joinNames(name, f.Name)
+				f.Name = joinNames(name, f.Name)
+				m[localKey{funcname, uint64(-offset) - f.Offset}] = f
 			}
 		}
 	}
-	return m
+	return m, nil
 }
 
-// Makes a map from <function name, offset in arg area> to name of field.
-func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]string {
-	m := make(map[localKey]string, 0)
+// Makes a map from <function name, offset in arg area> to field.
+func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) (map[localKey]Field, error) {
+	m := make(map[localKey]Field, 0)
 	r := w.Reader()
 	var funcname string
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		if e == nil {
 			break
 		}
 		switch e.Tag {
 		case dwarf.TagSubprogram:
-			funcname = e.Val(dwarf.AttrName).(string)
+			funcname, _ = e.Val(dwarf.AttrName).(string)
 		case dwarf.TagFormalParameter:
 			if e.Val(dwarf.AttrName) == nil {
 				continue
 			}
 			name := e.Val(dwarf.AttrName).(string)
-			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
-			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
+			ref, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+			typ := t[ref]
+			loc, _ := e.Val(dwarf.AttrLocation).([]uint8)
+			if typ == nil || len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
 				break
 			}
 			var offset int64
@@ -1174,21 +1381,22 @@ func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]
 				}
 			}
 			for _, f := range typ.Fields() {
-				m[localKey{funcname, uint64(offset)}] = joinNames(name, f.Name)
+				f.Name = joinNames(name, f.Name)
+				m[localKey{funcname, uint64(offset)}] = f
 			}
 		}
 	}
-	return m
+	return m, nil
 }
 
 // map from global address to Field at that address
-func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
+func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) (*heap, error) {
 	h := new(heap)
 	r := w.Reader()
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		if e == nil {
 			break
@@ -1196,10 +1404,11 @@ func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
 		if e.Tag != dwarf.TagVariable {
 			continue
 		}
-		name := e.Val(dwarf.AttrName).(string)
-		typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-		locexpr := e.Val(dwarf.AttrLocation).([]uint8)
-		if len(locexpr) == 0 || locexpr[0] != dw_op_addr {
+		name, _ := e.Val(dwarf.AttrName).(string)
+		ref, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+		typ := t[ref]
+		locexpr, _ := e.Val(dwarf.AttrLocation).([]uint8)
+		if len(locexpr) <= int(d.PtrSize) || locexpr[0] != dw_op_addr {
 			continue
 		}
 		loc := readPtr(d, locexpr[1:])
@@ -1212,7 +1421,7 @@ func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
 			h.Insert(loc+f.Offset, Field{f.Kind, 0, joinNames(name, f.Name), f.BaseType})
 		}
 	}
-	return h
+	return h, nil
 }
 
 // stack frames may be zero-sized, so we add call depth
@@ -1229,7 +1438,7 @@ func (d *Dump) appendEdge(edges []Edge, data []byte, off uint64, f Field) []Edge
 	p := readPtr(d, data[off:])
 	q := d.FindObj(p)
 	if q != ObjNil {
-		edges = append(edges, Edge{q, off, p - d.objects[q].Addr, f.Name})
+		edges = append(edges, Edge{q, off, p - d.Addr(q), f.Name})
 	}
 	return edges
 }
@@ -1273,10 +1482,40 @@ func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
 	return edges
 }
 
+// sliceElemSizes returns the sizes of the elements of the slice
+// types in t, by the BaseType of their fields.
+func sliceElemSizes(t map[dwarf.Offset]dwarfType) map[string]uint64 {
+	sizes := map[string]uint64{}
+	for _, x := range t {
+		s, ok := x.(*dwarfStructType)
+		if !ok || len(s.members) == 0 {
+			continue
+		}
+		f := s.Fields()
+		if len(f) != 1 || f[0].Kind != FieldKindSlice {
+			continue
+		}
+		if p, ok := s.members[0].type_.(*dwarfPtrType); ok && p.elem != nil {
+			sizes[f[0].BaseType] = p.elem.Size()
+		}
+	}
+	return sizes
+}
+
 // Names the fields it can for better debugging output
-func nameWithDwarf(d *Dump, execname string) {
-	w := getDwarf(execname)
-	t := typeMap(d, w)
+func nameWithDwarf(d *Dump, execname string) error {
+	w, err := getDwarf(execname)
+	if err != nil {
+		return err
+	}
+	t, err := typeMap(d, w)
+	if err != nil {
+		return err
+	}
+
+	d.mapTypes = findMapTypes(d, t)
+	d.hchan, d.chanTypes = findChanTypes(d, t)
+	d.elemSizes = sliceElemSizes(t)
 
 	// name fields in all types
 	m := make(map[string]dwarfType)
@@ -1291,6 +1530,12 @@ func nameWithDwarf(d *Dump, execname string) {
 			//log.Printf("type %s has no dwarf info", t.Name)
 			continue
 		}
+		if d.Version != Go13 {
+			// Later formats don't record the fields of types, so
+			// there is nothing to check the Dwarf type against.
+			t.Fields = dt.Fields()
+			continue
+		}
 		// Check that the Dwarf type is consistent with the type we got from
 		// the heap dump.  The heap dump type is the root truth, but it is
 		// missing non-pointer-bearing fields and has no field names.  If the
@@ -1308,7 +1553,7 @@ func nameWithDwarf(d *Dump, execname string) {
 		// in both kind and offset.
 		for _, f := range t.Fields {
 			if layout[f.Offset].Kind != f.Kind {
-				log.Printf("dwarf field kind doesn't match dump kind %s.%d dwarf=%d dump=%d", t.Name, f.Offset, layout[f.Offset].Kind, f.Kind)
+				d.logf("dwarf field kind doesn't match dump kind %s.%d dwarf=%d dump=%d", t.Name, f.Offset, layout[f.Offset].Kind, f.Kind)
 				consistent = false
 			}
 			delete(layout, f.Offset)
@@ -1317,7 +1562,7 @@ func nameWithDwarf(d *Dump, execname string) {
 		for _, f := range layout {
 			switch f.Kind {
 			case FieldKindPtr, FieldKindString, FieldKindSlice, FieldKindIface, FieldKindEface:
-				log.Printf("dwarf type has additional ptr field %s %d %d", f.Name, f.Offset, f.Kind)
+				d.logf("dwarf type has additional ptr field %s %d %d", f.Name, f.Offset, f.Kind)
 				consistent = false
 			}
 		}
@@ -1326,7 +1571,7 @@ func nameWithDwarf(d *Dump, execname string) {
 			// with fields from the Dwarf info.
 			t.Fields = df
 		} else {
-			log.Print("inconsistent type for", t.Name)
+			d.logf("inconsistent type for %s", t.Name)
 		}
 	}
 
@@ -1341,22 +1586,32 @@ func nameWithDwarf(d *Dump, execname string) {
 			continue
 		}
 		g := frames[frameKey{f.childaddr, f.Depth - 1}]
-		g.Parent = f
+		if g != nil {
+			g.Parent = f
+		}
 	}
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
 	}
 
 	// name all frame fields
-	locals := localsMap(d, w, t)
-	args := argsMap(d, w, t)
+	locals, err := localsMap(d, w, t)
+	if err != nil {
+		return err
+	}
+	args, err := argsMap(d, w, t)
+	if err != nil {
+		return err
+	}
 	for _, g := range d.Goroutines {
 		var c *StackFrame
 		for r := g.Bos; r != nil; r = r.Parent {
 			for i, f := range r.Fields {
-				name := locals[localKey{r.Name, uint64(len(r.Data)) - f.Offset}]
+				v := locals[localKey{r.Name, uint64(len(r.Data)) - f.Offset}]
+				name := v.Name
 				if name == "" && c != nil {
-					name = args[localKey{c.Name, f.Offset}]
+					v = args[localKey{c.Name, f.Offset}]
+					name = v.Name
 					if name != "" {
 						name = "outarg." + name
 					}
@@ -1365,48 +1620,78 @@ func nameWithDwarf(d *Dump, execname string) {
 					name = fmt.Sprintf("~%d", f.Offset)
 				}
 				r.Fields[i].Name = name
+				if f.Kind == FieldKindPtr && v.Kind == FieldKindPtr {
+					r.Fields[i].BaseType = v.BaseType
+				}
 			}
 			c = r
 		}
 	}
 
 	// naming for globals
-	globals := globalsMap(d, w, t)
+	globals, err := globalsMap(d, w, t)
+	if err != nil {
+		return err
+	}
 	for _, x := range []*Data{d.Data, d.Bss} {
-		for i, f := range x.Fields {
+		var fields []Field
+		end := uint64(0) // end of the last field we kept
+		for _, f := range x.Fields {
+			if f.Offset < end {
+				// Later formats record each pointer word of a
+				// multiword value (string, interface, ...) separately.
+				// The Dwarf field before this one already covers it.
+				continue
+			}
 			addr := x.Addr + f.Offset
 			a, v := globals.Lookup(addr)
 			if v == nil {
+				fields = append(fields, f)
+				end = f.Offset + d.PtrSize
 				continue
 			}
 			ff := v.(Field)
 			if a != addr {
 				ff.Name = fmt.Sprintf("%s:%d", ff.Name, addr-a)
 			}
+			if a != addr || d.fieldWords(ff.Kind) == 0 {
+				// Dwarf field doesn't line up; trust the dump.
+				ff.Kind = f.Kind
+				ff.BaseType = f.BaseType
+			}
 			ff.Offset = f.Offset
-			x.Fields[i] = ff
+			fields = append(fields, ff)
+			end = ff.Offset + d.fieldWords(ff.Kind)*d.PtrSize
 		}
+		x.Fields = fields
+	}
+	return nil
+}
+
+// fieldWords returns the number of pointer-sized words in a
+// pointer-bearing field of kind k, or 0 for other kinds.
+func (d *Dump) fieldWords(k FieldKind) uint64 {
+	switch k {
+	case FieldKindPtr:
+		return 1
+	case FieldKindString, FieldKindIface, FieldKindEface:
+		return 2
+	case FieldKindSlice:
+		return 3
 	}
+	return 0
 }
 
-func link(d *Dump) {
+func link(d *Dump, c *config) error {
+	workers := c.workers
 	// sort objects in increasing address order
-	sort.Sort(byAddr(d.objects))
+	sortObjects(&d.objects, workers)
 
 	// initialize index array
-	d.idx = make([]ObjId, (d.HeapEnd-d.HeapStart+bucketSize-1)/bucketSize)
-	for i := len(d.idx) - 1; i >= 0; i-- {
-		d.idx[i] = ObjId(len(d.objects))
-	}
-	for i := len(d.objects) - 1; i >= 0; i-- {
-		// Note: we iterate in reverse order so that the object with
-		// the lowest address that intersects a bucket will win.
-		lo := (d.objects[i].Addr - d.HeapStart) / bucketSize
-		hi := (d.objects[i].Addr + d.objects[i].Ft.Size - 1 - d.HeapStart) / bucketSize
-		for j := lo; j <= hi; j++ {
-			d.idx[j] = ObjId(i)
-		}
+	if uint64(d.NumObjects()) > math.MaxUint32 {
+		return fmt.Errorf("too many objects: %d", d.NumObjects())
 	}
+	buildIndex(d, workers)
 
 	// initialize some maps used for linking
 	frames := make(map[frameKey]*StackFrame, len(d.Frames))
@@ -1415,9 +1700,11 @@ func link(d *Dump) {
 	}
 
 	// link stack frames to objects
-	for _, f := range d.Frames {
-		f.Edges = d.appendFields(f.Edges, f.Data, f.Fields)
-	}
+	parallelFor(len(d.Frames), workers, func(lo, hi int) {
+		for _, f := range d.Frames[lo:hi] {
+			f.Edges = d.appendFields(f.Edges, f.Data, f.Fields)
+		}
+	})
 
 	// link up frames in sequence
 	for _, f := range d.Frames {
@@ -1425,6 +1712,9 @@ func link(d *Dump) {
 			continue
 		}
 		g := frames[frameKey{f.childaddr, f.Depth - 1}]
+		if g == nil {
+			return fmt.Errorf("stack frame %s at %x has no child frame at %x", f.Name, f.Addr, f.childaddr)
+		}
 		g.Parent = f
 	}
 
@@ -1432,49 +1722,120 @@ func link(d *Dump) {
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
 		if g.Bos == nil {
-			log.Fatal("bos missing")
+			return fmt.Errorf("goroutine %x: bottom of stack frame %x missing", g.Addr, g.bosaddr)
 		}
 		for f := g.Bos; f != nil; f = f.Parent {
 			f.Goroutine = g
 		}
-		x := d.FindObj(g.ctxtaddr)
-		if x != ObjNil {
-			g.Ctxt = x
-		}
+		g.Ctxt = d.FindObj(g.ctxtaddr)
 	}
 
 	// link data roots
 	for _, x := range []*Data{d.Data, d.Bss} {
-		x.Edges = d.appendFields(x.Edges, x.Data, x.Fields)
+		x.Edges = d.appendFieldsParallel(x.Edges, x.Data, x.Fields, workers)
 	}
 
 	// link other roots
 	for _, r := range d.Otherroots {
 		x := d.FindObj(r.toaddr)
 		if x != ObjNil {
-			r.Edges = append(r.Edges, Edge{x, 0, r.toaddr - d.objects[x].Addr, ""})
+			r.Edges = append(r.Edges, Edge{x, 0, r.toaddr - d.Addr(x), ""})
 		}
 	}
 
-	// Add links for finalizers
-	// TODO: how do we represent these?
-	/*
-		for _, f := range d.Finalizers {
-			x := d.FindObj(f.obj)
-			for _, addr := range []uint64{f.fn, f.fint, f.ot} {
-				y := d.FindObj(addr)
-				if x != nil && y != nil {
-					x.Edges = append(x.Edges, Edge{y, 0, addr - y.Addr, "finalizer", 0})
-				}
-			}
-		}
-	*/
+	// Add links for finalizers.  A pending finalizer is not a
+	// root: it refers to its object without keeping it alive.
+	for _, f := range d.Finalizers {
+		f.Edges = d.finalizerEdges(f.Edges, f.ObjAddr, f.FnAddr, f.FintAddr, f.OtAddr)
+	}
 	for _, f := range d.QFinal {
-		for _, addr := range []uint64{f.obj, f.fn, f.fint, f.ot} {
-			x := d.FindObj(addr)
-			if x != ObjNil {
-				f.Edges = append(f.Edges, Edge{x, 0, addr - d.objects[x].Addr, ""})
+		f.Edges = d.finalizerEdges(f.Edges, f.ObjAddr, f.FnAddr, f.FintAddr, f.OtAddr)
+	}
+	if c.finalizerRoots {
+		if err := addFinalizerRoots(d); err != nil {
+			return err
+		}
+	}
+
+	linkGoroutineState(d)
+	return nil
+}
+
+// finalizerEdges returns edges to the objects of a finalizer
+// that are in the heap.
+func (d *Dump) finalizerEdges(edges []Edge, obj, fn, fint, ot uint64) []Edge {
+	for _, f := range []struct {
+		addr uint64
+		name string
+	}{{obj, "obj"}, {fn, "fn"}, {fint, "fint"}, {ot, "ot"}} {
+		x := d.FindObj(f.addr)
+		if x != ObjNil {
+			edges = append(edges, Edge{x, 0, f.addr - d.Addr(x), f.name})
+		}
+	}
+	return edges
+}
+
+// addFinalizerRoots adds a root for each pending finalizer.  Like
+// the garbage collector, it treats the finalizer's function and
+// everything its object refers to as reachable, but not the object
+// itself, so that it can be finalized.
+func addFinalizerRoots(d *Dump) error {
+	for _, f := range d.Finalizers {
+		r := &OtherRoot{Description: fmt.Sprintf("finalizer for %x", f.ObjAddr), toaddr: f.FnAddr}
+		if x := d.FindObj(f.FnAddr); x != ObjNil {
+			r.Edges = append(r.Edges, Edge{x, 0, f.FnAddr - d.Addr(x), "fn"})
+		}
+		if x := d.FindObj(f.ObjAddr); x != ObjNil {
+			edges, err := d.ReadEdges(x)
+			if err != nil {
+				return err
 			}
+			r.Edges = append(r.Edges, edges...)
+		}
+		d.finalizerRoots = append(d.finalizerRoots, r)
+	}
+	return nil
+}
+
+// linkGoroutineState connects the defers, panics and threads
+// of a dump to their goroutines.
+func linkGoroutineState(d *Dump) {
+	defers := make(map[uint64]*Defer, len(d.Defers))
+	for _, x := range d.Defers {
+		defers[x.Addr] = x
+		x.Fn = d.FindObj(x.FnAddr)
+	}
+	panics := make(map[uint64]*Panic, len(d.Panics))
+	for _, x := range d.Panics {
+		panics[x.Addr] = x
+		x.Data = d.FindObj(x.DataAddr)
+	}
+	threads := make(map[uint64]*OSThread, len(d.Osthreads))
+	for _, t := range d.Osthreads {
+		threads[t.Addr] = t
+	}
+	for _, x := range d.Defers {
+		x.Link = defers[x.link]
+	}
+	for _, x := range d.Panics {
+		x.Link = panics[x.link]
+		x.Defer = defers[x.defr]
+	}
+
+	for _, g := range d.Goroutines {
+		g.Thread = threads[g.maddr]
+		g.Defers = g.Defers[:0]
+		// The lists can't be longer than all the records;
+		// the bound protects against a corrupt cyclic list.
+		for x := defers[g.deferaddr]; x != nil && len(g.Defers) < len(d.Defers); x = x.Link {
+			x.Goroutine = g
+			g.Defers = append(g.Defers, x)
+		}
+		g.Panics = g.Panics[:0]
+		for x := panics[g.panicaddr]; x != nil && len(g.Panics) < len(d.Panics); x = x.Link {
+			x.Goroutine = g
+			g.Panics = append(g.Panics, x)
 		}
 	}
 }
@@ -1501,120 +1862,111 @@ func nameFallback(d *Dump) {
 	}
 }
 
-// needs to be kept in sync with src/pkg/runtime/chan.h in
-// the main Go distribution.
-var chanFields = map[uint64]map[uint64]string{
-	4: map[uint64]string{
-		0:  "len",
-		4:  "cap",
-		20: "next send index",
-		24: "next receive index",
-	},
-	8: map[uint64]string{
-		0:  "len",
-		8:  "cap",
-		32: "next send index",
-		40: "next receive index",
-	},
+func nameFullTypes(d *Dump, workers int) error {
+	errs := make([]error, len(d.FTList))
+	parallelFor(len(d.FTList), workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			errs[i] = nameFullType(d, d.FTList[i])
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func nameFullTypes(d *Dump) {
-	for _, ft := range d.FTList {
-		t := ft.Typ
-		switch {
-		case ft.Typ == nil && ft.Kind == TypeKindConservative:
-			// could all be pointers
-			for i := uint64(0); i < ft.Size; i += d.PtrSize {
-				ft.Fields = append(ft.Fields, Field{FieldKindPtr, i, fmt.Sprintf("~%d", i), ""})
+// nameFullType fills in the fields of ft.
+func nameFullType(d *Dump, ft *FullType) error {
+	t := ft.Typ
+	switch {
+	case ft.Typ == nil && ft.Kind == TypeKindConservative:
+		// could all be pointers
+		for i := uint64(0); i < ft.Size; i += d.PtrSize {
+			ft.Fields = append(ft.Fields, Field{FieldKindPtr, i, fmt.Sprintf("~%d", i), ""})
+		}
+	case ft.Typ == nil && ft.Kind == TypeKindObject && ft.Fields != nil:
+		// pointer layout came from the dump.  Only name the fields.
+		for i := range ft.Fields {
+			ft.Fields[i].Name = fmt.Sprintf("~%d", ft.Fields[i].Offset)
+		}
+	case ft.Typ == nil && ft.Kind == TypeKindObject:
+		// no pointers.  Emit psuedo field records
+		for i := uint64(0); i < ft.Size; i += 16 {
+			if i >= 1<<16 {
+				// ignore >64KB of data
+				ft.Fields = append(ft.Fields, Field{FieldKindBytesElided, i, fmt.Sprintf("offset %x", i), ""})
+				i = ft.Size
+				break
 			}
-		case ft.Typ == nil && ft.Kind == TypeKindObject:
-			// no pointers.  Emit psuedo field records
-			for i := uint64(0); i < ft.Size; i += 16 {
-				if i >= 1<<16 {
-					// ignore >64KB of data
-					ft.Fields = append(ft.Fields, Field{FieldKindBytesElided, i, fmt.Sprintf("offset %x", i), ""})
-					i = ft.Size
-					break
-				}
-				s := ft.Size - i
-				if s > 16 {
-					s = 16
-				}
-				switch s {
-				case 16:
-					ft.Fields = append(ft.Fields, Field{FieldKindBytes16, i, fmt.Sprintf("offset %x", i), ""})
-				case 8:
-					ft.Fields = append(ft.Fields, Field{FieldKindBytes8, i, fmt.Sprintf("offset %x", i), ""})
-				default:
-					log.Fatalf("weird size obj", ft.Size)
+			s := ft.Size - i
+			if s > 16 {
+				s = 16
+			}
+			switch s {
+			case 16:
+				ft.Fields = append(ft.Fields, Field{FieldKindBytes16, i, fmt.Sprintf("offset %x", i), ""})
+			case 8:
+				ft.Fields = append(ft.Fields, Field{FieldKindBytes8, i, fmt.Sprintf("offset %x", i), ""})
+			default:
+				return fmt.Errorf("weird size obj %d", ft.Size)
+			}
+		}
+	case ft.Typ != nil && ft.Kind == TypeKindObject:
+		ft.Fields = ft.Typ.Fields
+	case ft.Typ != nil && ft.Kind == TypeKindArray:
+		t := ft.Typ
+		for i := uint64(0); i <= ft.Size-t.Size; i += t.Size {
+			for _, f := range t.Fields {
+				var name string
+				if f.Name != "" {
+					name = fmt.Sprintf("%d.%s", i/t.Size, f.Name)
+				} else {
+					name = fmt.Sprintf("%d", i/t.Size)
 				}
+				ft.Fields = append(ft.Fields, Field{f.Kind, i + f.Offset, name, f.BaseType})
 			}
-		case ft.Typ != nil && ft.Kind == TypeKindObject:
-			ft.Fields = ft.Typ.Fields
-		case ft.Typ != nil && ft.Kind == TypeKindArray:
-			t := ft.Typ
-			for i := uint64(0); i <= ft.Size-t.Size; i += t.Size {
+		}
+	case ft.Typ != nil && ft.Kind == TypeKindChan:
+		l := d.chanLayout()
+		if l == nil {
+			return fmt.Errorf("can't find channel header info for ptr size %d", d.PtrSize)
+		}
+		fmap := map[uint64]string{
+			l.qcount:   "len",
+			l.dataqsiz: "cap",
+			l.sendx:    "next send index",
+			l.recvx:    "next receive index",
+		}
+		k := FieldKindUInt64
+		if d.PtrSize == 4 {
+			k = FieldKindUInt32
+		}
+		for i := uint64(0); i < d.HChanSize; i += d.PtrSize {
+			if name, ok := fmap[i]; ok {
+				ft.Fields = append(ft.Fields, Field{k, i, name, ""})
+			} else {
+				ft.Fields = append(ft.Fields, Field{k, i, "chanhdr", ""})
+			}
+		}
+		if t.Size > 0 {
+			for i := d.HChanSize; i <= ft.Size-t.Size; i += t.Size {
 				for _, f := range t.Fields {
 					var name string
 					if f.Name != "" {
-						name = fmt.Sprintf("%d.%s", i/t.Size, f.Name)
+						name = fmt.Sprintf("%d.%s", (i-d.HChanSize)/t.Size, f.Name)
 					} else {
-						name = fmt.Sprintf("%d", i/t.Size)
+						name = fmt.Sprintf("%d", (i-d.HChanSize)/t.Size)
 					}
 					ft.Fields = append(ft.Fields, Field{f.Kind, i + f.Offset, name, f.BaseType})
 				}
 			}
-		case ft.Typ != nil && ft.Kind == TypeKindChan:
-			fmap := chanFields[d.PtrSize]
-			if fmap == nil {
-				log.Fatal("can't find channel header info for ptr size")
-			}
-			k := FieldKindUInt64
-			if d.PtrSize == 4 {
-				k = FieldKindUInt32
-			}
-			for i := uint64(0); i < d.HChanSize; i += d.PtrSize {
-				if name, ok := fmap[i]; ok {
-					ft.Fields = append(ft.Fields, Field{k, i, name, ""})
-				} else {
-					ft.Fields = append(ft.Fields, Field{k, i, "chanhdr", ""})
-				}
-			}
-			if t.Size > 0 {
-				for i := d.HChanSize; i <= ft.Size-t.Size; i += t.Size {
-					for _, f := range t.Fields {
-						var name string
-						if f.Name != "" {
-							name = fmt.Sprintf("%d.%s", (i-d.HChanSize)/t.Size, f.Name)
-						} else {
-							name = fmt.Sprintf("%d", (i-d.HChanSize)/t.Size)
-						}
-						ft.Fields = append(ft.Fields, Field{f.Kind, i + f.Offset, name, f.BaseType})
-					}
-				}
-			}
-		default:
-			log.Fatal("bad type/kind combo", ft.Typ, ft.Kind)
 		}
+	default:
+		return fmt.Errorf("bad type/kind combo %v %d", ft.Typ, ft.Kind)
 	}
-}
-
-type byAddr []object
-
-func (a byAddr) Len() int           { return len(a) }
-func (a byAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
-
-func Read(dumpname, execname string) *Dump {
-	d := rawRead(dumpname)
-	if execname != "" {
-		nameWithDwarf(d, execname)
-	} else {
-		nameFallback(d)
-	}
-	nameFullTypes(d)
-	link(d)
-	return d
+	return nil
 }
 
 func readPtr(d *Dump, b []byte) uint64 {
@@ -1624,7 +1976,7 @@ func readPtr(d *Dump, b []byte) uint64 {
 	case 8:
 		return d.Order.Uint64(b)
 	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
-		return 0
+		// rawRead rejects any other pointer size.
+		panic(fmt.Sprintf("unsupported PtrSize=%d", d.PtrSize))
 	}
 }
@@ -0,0 +1,196 @@
+package read
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// A Referrer is a reference to an object, from another object or
+// from a root.
+type Referrer struct {
+	From ObjId // referring object, or ObjNil for a root
+	Root *Root // referring root, if From is ObjNil
+	Edge Edge  // the reference itself; Edge.To is the referred-to object
+}
+
+// Referrers returns the references to object x, from other objects
+// and from the roots of the heap (see Roots).  The first call builds
+// an index of all the edges in the heap, which takes about as long as
+// a full traversal; the index is kept in the dump's index file if
+// there is one (see Cache).  It is safe to call Referrers from
+// multiple goroutines.  It panics with an *ObjectError if an
+// object can't be decoded.
+func (d *Dump) Referrers(x ObjId) []Referrer {
+	r, err := d.ReadReferrers(x)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// ReadReferrers is like Referrers but returns an error instead
+// of panicking.
+func (d *Dump) ReadReferrers(x ObjId) ([]Referrer, error) {
+	d.refOnce.Do(d.buildReferrers)
+	if d.refErr != nil {
+		return nil, d.refErr
+	}
+	n := ObjId(d.NumObjects())
+	var r []Referrer
+	var edges []Edge
+	for _, y := range d.refs[d.refOff[x]:d.refOff[x+1]] {
+		if ObjId(y) >= n {
+			root := &d.refRoots[ObjId(y)-n]
+			for _, e := range root.Edges {
+				if e.To == x {
+					r = append(r, Referrer{ObjNil, root, e})
+				}
+			}
+			continue
+		}
+		var err error
+		edges, err = d.EdgesInto(ObjId(y), edges[:0])
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			if e.To == x {
+				r = append(r, Referrer{ObjId(y), nil, e})
+			}
+		}
+	}
+	return r, nil
+}
+
+// name of the index file section holding the referrers index
+const referrersSection = "referrers"
+
+// buildReferrers builds the reverse edge index in compressed
+// sparse row form: for each object, a run of the distinct objects
+// and roots that refer to it.
+func (d *Dump) buildReferrers() {
+	d.refRoots = d.Roots()
+	if b, ok := d.CacheLoad(referrersSection); ok {
+		if d.decodeReferrers(b) {
+			return
+		}
+		d.logf("ignoring bad referrers index")
+	}
+
+	n := d.NumObjects()
+	m := n + len(d.refRoots)
+	// visit calls f with the distinct targets of each referrer,
+	// in increasing referrer order.
+	visit := func(f func(y uint32, to []uint32)) error {
+		var edges []Edge
+		var to []uint32
+		for y := 0; y < m; y++ {
+			if y < n {
+				var err error
+				edges, err = d.EdgesInto(ObjId(y), edges[:0])
+				if err != nil {
+					return err
+				}
+			} else {
+				edges = d.refRoots[y-n].Edges
+			}
+			to = to[:0]
+			for _, e := range edges {
+				to = append(to, uint32(e.To))
+			}
+			sort.Slice(to, func(i, j int) bool { return to[i] < to[j] })
+			k := 0
+			for i, x := range to {
+				if i == 0 || x != to[i-1] {
+					to[k] = x
+					k++
+				}
+			}
+			f(uint32(y), to[:k])
+		}
+		return nil
+	}
+
+	off := make([]int, n+1)
+	err := visit(func(y uint32, to []uint32) {
+		for _, x := range to {
+			off[x+1]++
+		}
+	})
+	if err != nil {
+		d.refErr = err
+		return
+	}
+	for x := 1; x <= n; x++ {
+		off[x] += off[x-1]
+	}
+	refs := make([]uint32, off[n])
+	fill := make([]int, n)
+	copy(fill, off)
+	err = visit(func(y uint32, to []uint32) {
+		for _, x := range to {
+			refs[fill[x]] = y
+			fill[x]++
+		}
+	})
+	if err != nil {
+		d.refErr = err
+		return
+	}
+	d.refOff, d.refs = off, refs
+
+	if err := d.CacheStore(referrersSection, d.encodeReferrers()); err != nil {
+		d.logf("saving referrers index: %v", err)
+	}
+}
+
+// encodeReferrers encodes the referrers index as the number of
+// referrers of each object followed by all the referrers,
+// as uvarints.
+func (d *Dump) encodeReferrers() []byte {
+	var b []byte
+	n := d.NumObjects()
+	for x := 0; x < n; x++ {
+		b = binary.AppendUvarint(b, uint64(d.refOff[x+1]-d.refOff[x]))
+	}
+	for _, y := range d.refs {
+		b = binary.AppendUvarint(b, uint64(y))
+	}
+	return b
+}
+
+// decodeReferrers sets the referrers index from b, as written
+// by encodeReferrers.  It reports whether b was valid.
+func (d *Dump) decodeReferrers(b []byte) bool {
+	n := d.NumObjects()
+	m := uint64(n + len(d.refRoots))
+	next := func() (uint64, bool) {
+		v, k := binary.Uvarint(b)
+		if k <= 0 {
+			return 0, false
+		}
+		b = b[k:]
+		return v, true
+	}
+	off := make([]int, n+1)
+	for x := 0; x < n; x++ {
+		c, ok := next()
+		if !ok || c > uint64(len(b)) {
+			return false
+		}
+		off[x+1] = off[x] + int(c)
+	}
+	refs := make([]uint32, off[n])
+	for i := range refs {
+		y, ok := next()
+		if !ok || y >= m {
+			return false
+		}
+		refs[i] = uint32(y)
+	}
+	if len(b) != 0 {
+		return false
+	}
+	d.refOff, d.refs = off, refs
+	return true
+}
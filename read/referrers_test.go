@@ -0,0 +1,58 @@
+package read
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestReadReferrers checks that every edge of every object is
+// found among the referrers of the object it points to.
+func TestReadReferrers(t *testing.T) {
+	d := openTest(t, testDump(t))
+	var edges []Edge
+	for i := 0; i < d.NumObjects(); i++ {
+		y := ObjId(i)
+		var err error
+		edges, err = d.EdgesInto(y, edges[:0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range edges {
+			refs, err := d.ReadReferrers(e.To)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, r := range refs {
+				if r.From == y && r.Edge == e {
+					found = true
+				}
+				if r.Edge.To != e.To || (r.From == ObjNil) != (r.Root != nil) {
+					t.Fatalf("object %x has bad referrer %+v", d.Addr(e.To), r)
+				}
+			}
+			if !found {
+				t.Fatalf("edge %+v from %x missing from referrers", e, d.Addr(y))
+			}
+		}
+	}
+}
+
+func TestReadReferrersAfterClose(t *testing.T) {
+	d := openTest(t, testDump(t))
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var oe *ObjectError
+	if _, err := d.ReadReferrers(0); !errors.As(err, &oe) || !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("ReadReferrers after Close returned %v, want an *ObjectError wrapping os.ErrClosed", err)
+	}
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.As(err, &oe) {
+			t.Fatalf("Referrers after Close panicked with %v, want an *ObjectError", err)
+		}
+	}()
+	d.Referrers(0)
+}
@@ -0,0 +1,118 @@
+package read
+
+import (
+	"fmt"
+)
+
+// A RootKind says why the garbage collector considers a Root live.
+type RootKind int
+
+const (
+	RootStack      RootKind = iota // locals and arguments of a stack frame
+	RootGlobal                     // global variables, in the data or bss segment
+	RootOther                      // other roots reported by the runtime
+	RootQFinalizer                 // finalizer ready to run
+	RootContext                    // closure context of a goroutine
+	RootFinalizer                  // pending finalizer, with FinalizerRoots
+)
+
+var rootKindNames = []string{
+	RootStack:      "stack",
+	RootGlobal:     "global",
+	RootOther:      "other",
+	RootQFinalizer: "queued finalizer",
+	RootContext:    "goroutine context",
+	RootFinalizer:  "finalizer",
+}
+
+func (k RootKind) String() string {
+	if k >= 0 && int(k) < len(rootKindNames) {
+		return rootKindNames[k]
+	}
+	return fmt.Sprintf("RootKind(%d)", int(k))
+}
+
+// A Root is a set of pointers into the heap that the garbage
+// collector treats as live.
+type Root struct {
+	Kind        RootKind
+	Description string
+	Edges       []Edge
+
+	Frame     *StackFrame // for RootStack
+	Goroutine *GoRoutine  // for RootStack and RootContext
+}
+
+// Roots returns all the roots of the heap.  Every object reachable
+// from these roots is live; see Reachable.  Roots with no edges into
+// the heap are omitted.
+func (d *Dump) Roots() []Root {
+	var roots []Root
+	add := func(r Root) {
+		if len(r.Edges) > 0 {
+			roots = append(roots, r)
+		}
+	}
+	for _, f := range d.Frames {
+		add(Root{Kind: RootStack, Description: f.Name, Edges: f.Edges, Frame: f, Goroutine: f.Goroutine})
+	}
+	for _, x := range []struct {
+		name string
+		data *Data
+	}{{"data", d.Data}, {"bss", d.Bss}} {
+		if x.data != nil {
+			add(Root{Kind: RootGlobal, Description: x.name, Edges: x.data.Edges})
+		}
+	}
+	for _, r := range d.Otherroots {
+		add(Root{Kind: RootOther, Description: r.Description, Edges: r.Edges})
+	}
+	for _, f := range d.QFinal {
+		add(Root{Kind: RootQFinalizer, Description: fmt.Sprintf("queued finalizer for %x", f.ObjAddr), Edges: f.Edges})
+	}
+	for _, g := range d.Goroutines {
+		if g.Ctxt == ObjNil {
+			continue
+		}
+		e := Edge{g.Ctxt, 0, g.ctxtaddr - d.Addr(g.Ctxt), "ctxt"}
+		add(Root{Kind: RootContext, Description: fmt.Sprintf("goroutine %d context", g.Goid), Edges: []Edge{e}, Goroutine: g})
+	}
+	for _, r := range d.finalizerRoots {
+		add(Root{Kind: RootFinalizer, Description: r.Description, Edges: r.Edges})
+	}
+	return roots
+}
+
+// Reachable returns, for each object, whether it is reachable
+// from the roots of the heap.  It is safe to call concurrently with
+// other calls that are.  It fails with an *ObjectError if an object
+// can't be decoded.
+func (d *Dump) Reachable() ([]bool, error) {
+	reachable := make([]bool, d.NumObjects())
+	var q []ObjId
+	for _, r := range d.Roots() {
+		for _, e := range r.Edges {
+			if !reachable[e.To] {
+				reachable[e.To] = true
+				q = append(q, e.To)
+			}
+		}
+	}
+	var edges []Edge
+	var err error
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		edges, err = d.EdgesInto(x, edges[:0])
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			if !reachable[e.To] {
+				reachable[e.To] = true
+				q = append(q, e.To)
+			}
+		}
+	}
+	return reachable, nil
+}
@@ -0,0 +1,33 @@
+package read
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestReachable(t *testing.T) {
+	d := openTest(t, testDump(t))
+	reachable, err := d.Reachable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, r := range reachable {
+		if r {
+			n++
+		}
+	}
+	if n == 0 {
+		t.Fatal("no reachable objects")
+	}
+
+	// Once the dump is closed, object contents can't be read.
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var oe *ObjectError
+	if _, err := d.Reachable(); !errors.As(err, &oe) || !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Reachable after Close returned %v, want an *ObjectError wrapping os.ErrClosed", err)
+	}
+}
@@ -0,0 +1,396 @@
+package read
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+)
+
+// Params holds the dump-wide parameters from the header and the
+// params record of a heap dump.
+type Params struct {
+	Version    Version // format of the dump file
+	Order      binary.ByteOrder
+	PtrSize    uint64 // in bytes
+	HChanSize  uint64 // channel header size in bytes (go1.3 only)
+	HeapStart  uint64
+	HeapEnd    uint64
+	TheChar    byte   // architecture character (before go1.7)
+	Experiment string // GOEXPERIMENT (before go1.7)
+	Arch       string // GOARCH (go1.7 and later)
+	GoVersion  string // runtime build version (go1.17 and later), or GOEXPERIMENT (go1.7 to go1.16)
+	Ncpu       uint64
+}
+
+// An ObjectRecord describes one object in a heap dump.  The contents
+// of the object are not included; they are at Offset in the dump.
+type ObjectRecord struct {
+	Addr     uint64
+	TypeAddr uint64   // 0 if unknown
+	Type     *Type    // nil if unknown
+	Kind     TypeKind // always TypeKindObject if Type is nil
+	Size     uint64
+	Offset   int64   // position of object contents in dump file
+	Fields   []Field // pointer layout, for formats without object types
+
+	start int64 // position of the record in dump file
+}
+
+// A Visitor is called by Scan for each record of a heap dump.  If a
+// method returns an error, Scan stops and reports it as the Err of a
+// *FormatError.
+type Visitor interface {
+	Params(p *Params) error
+	Type(t *Type) error
+	Object(o *ObjectRecord) error
+	Itab(addr uint64, ptr bool) error
+	GoRoutine(g *GoRoutine) error
+	StackFrame(f *StackFrame) error
+	OtherRoot(r *OtherRoot) error
+	Finalizer(f *Finalizer) error
+	QFinalizer(f *QFinalizer) error
+	Data(x *Data) error
+	Bss(x *Data) error
+	OSThread(t *OSThread) error
+	MemStats(m *runtime.MemStats) error
+	Defer(x *Defer) error
+	Panic(x *Panic) error
+	MemProf(e *MemProfEntry) error
+	AllocSample(s *AllocSample) error
+}
+
+// NopVisitor ignores all records.  Embed it in a Visitor that is
+// only interested in some kinds of records.
+type NopVisitor struct{}
+
+func (NopVisitor) Params(p *Params) error             { return nil }
+func (NopVisitor) Type(t *Type) error                 { return nil }
+func (NopVisitor) Object(o *ObjectRecord) error       { return nil }
+func (NopVisitor) Itab(addr uint64, ptr bool) error   { return nil }
+func (NopVisitor) GoRoutine(g *GoRoutine) error       { return nil }
+func (NopVisitor) StackFrame(f *StackFrame) error     { return nil }
+func (NopVisitor) OtherRoot(r *OtherRoot) error       { return nil }
+func (NopVisitor) Finalizer(f *Finalizer) error       { return nil }
+func (NopVisitor) QFinalizer(f *QFinalizer) error     { return nil }
+func (NopVisitor) Data(x *Data) error                 { return nil }
+func (NopVisitor) Bss(x *Data) error                  { return nil }
+func (NopVisitor) OSThread(t *OSThread) error         { return nil }
+func (NopVisitor) MemStats(m *runtime.MemStats) error { return nil }
+func (NopVisitor) Defer(x *Defer) error               { return nil }
+func (NopVisitor) Panic(x *Panic) error               { return nil }
+func (NopVisitor) MemProf(e *MemProfEntry) error      { return nil }
+func (NopVisitor) AllocSample(s *AllocSample) error   { return nil }
+
+// Scan decodes the heap dump read from r, calling v for each record
+// in the order they appear.  Object contents are skipped, so r need
+// not support random access.  Scan retains only the types and memory
+// profile buckets, which later records refer to.  Duplicate type
+// records are reported once.
+func Scan(r io.Reader, v Visitor) error {
+	br := &myReader{r: bufio.NewReader(r)}
+	f, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	return scanRecords(br, f, v, nil)
+}
+
+// scanAt is like Scan, but reads the size bytes of r, jumping over
+// object contents instead of reading them.
+func scanAt(r io.ReaderAt, size int64, v Visitor) error {
+	br := newReaderAt(r, 0, size)
+	f, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	return scanRecords(br, f, v, nil)
+}
+
+// readHeader reads the header line of a dump and returns the format
+// it announces.
+func readHeader(br *myReader) (*format, error) {
+	hdr, prefix, err := br.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	f := findFormat(string(hdr))
+	if prefix || f == nil {
+		return nil, ErrBadHeader
+	}
+	return f, nil
+}
+
+// scanRecords decodes the records following the header, calling v
+// for each.  If skim is not nil, object records are only framed:
+// skim is called with the start and end of each, and v.Object is
+// not called.
+func scanRecords(br *myReader, f *format, v Visitor, skim func(start, end int64) error) error {
+	s := &scanner{
+		r:       br,
+		f:       f,
+		v:       v,
+		skim:    skim,
+		types:   map[uint64]*Type{},
+		memprof: map[uint64]*MemProfEntry{},
+	}
+	s.params.Version = f.version
+	for {
+		start := br.Count()
+		kind := readUint64(br)
+		done, err := s.record(start, kind)
+		if err == nil {
+			err = br.err
+		}
+		if err != nil {
+			return &FormatError{start, kind, err}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+type scanner struct {
+	r       *myReader
+	f       *format
+	v       Visitor
+	skim    func(start, end int64) error // see scanRecords
+	params  Params
+	types   map[uint64]*Type         // types seen so far, by address
+	memprof map[uint64]*MemProfEntry // profile buckets, by address
+}
+
+// record decodes one record of the given kind and hands it to
+// the visitor.  It reports whether the record was the last one.
+// Decoding errors are left in s.r.
+func (s *scanner) record(start int64, kind uint64) (bool, error) {
+	r, f, v := s.r, s.f, s.v
+	switch kind {
+	case tagObject:
+		if s.skim != nil {
+			f.skipObject(r, f)
+			if r.err != nil {
+				return false, nil
+			}
+			return false, s.skim(start, r.Count())
+		}
+		o := f.readObject(r, f)
+		o.start = start
+		if r.err != nil {
+			return false, nil
+		}
+		if o.TypeAddr != 0 {
+			o.Type = s.types[o.TypeAddr]
+			if o.Type == nil {
+				return false, errors.New("object type used before it appears")
+			}
+		}
+		return false, v.Object(&o)
+	case tagEOF:
+		if s.params.PtrSize == 0 {
+			return false, errors.New("no params record before eof")
+		}
+		return true, nil
+	case tagOtherRoot:
+		t := &OtherRoot{}
+		t.Description = readString(r)
+		t.toaddr = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.OtherRoot(t)
+	case tagType:
+		typ := f.readType(r, f)
+		if r.err != nil {
+			return false, nil
+		}
+		// Note: there may be duplicate type records in a dump.
+		// The duplicates get thrown away here.
+		if _, ok := s.types[typ.Addr]; ok {
+			return false, nil
+		}
+		s.types[typ.Addr] = typ
+		return false, v.Type(typ)
+	case tagGoRoutine:
+		g := &GoRoutine{}
+		g.Addr = readUint64(r)
+		g.bosaddr = readUint64(r)
+		g.Goid = readUint64(r)
+		g.Gopc = readUint64(r)
+		g.Status = readUint64(r)
+		g.IsSystem = readBool(r)
+		g.IsBackground = readBool(r)
+		g.WaitSince = readUint64(r)
+		g.WaitReason = readString(r)
+		g.ctxtaddr = readUint64(r)
+		g.maddr = readUint64(r)
+		g.deferaddr = readUint64(r)
+		g.panicaddr = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.GoRoutine(g)
+	case tagStackFrame:
+		t := &StackFrame{}
+		t.Addr = readUint64(r)
+		t.Depth = readUint64(r)
+		t.childaddr = readUint64(r)
+		t.Data = readBytes(r)
+		t.Entry = readUint64(r)
+		t.PC = readUint64(r)
+		readUint64(r) // continpc
+		t.Name = readString(r)
+		t.Fields = f.readFields(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.StackFrame(t)
+	case tagParams:
+		f.readParams(r, &s.params)
+		if r.err != nil {
+			return false, nil
+		}
+		p := s.params
+		return false, v.Params(&p)
+	case tagFinalizer:
+		t := &Finalizer{}
+		t.ObjAddr = readUint64(r)
+		t.FnAddr = readUint64(r)
+		t.Code = readUint64(r)
+		t.FintAddr = readUint64(r)
+		t.OtAddr = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.Finalizer(t)
+	case tagQFinal:
+		t := &QFinalizer{}
+		t.ObjAddr = readUint64(r)
+		t.FnAddr = readUint64(r)
+		t.Code = readUint64(r)
+		t.FintAddr = readUint64(r)
+		t.OtAddr = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.QFinalizer(t)
+	case tagData, tagBss:
+		t := &Data{}
+		t.Addr = readUint64(r)
+		t.Data = readBytes(r)
+		t.Fields = f.readFields(r)
+		if r.err != nil {
+			return false, nil
+		}
+		if kind == tagData {
+			return false, v.Data(t)
+		}
+		return false, v.Bss(t)
+	case tagItab:
+		addr, ptr := f.readItab(r, s.types)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.Itab(addr, ptr)
+	case tagOSThread:
+		t := &OSThread{}
+		t.Addr = readUint64(r)
+		t.Id = readUint64(r)
+		t.ProcId = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.OSThread(t)
+	case tagMemStats:
+		t := &runtime.MemStats{}
+		t.Alloc = readUint64(r)
+		t.TotalAlloc = readUint64(r)
+		t.Sys = readUint64(r)
+		t.Lookups = readUint64(r)
+		t.Mallocs = readUint64(r)
+		t.Frees = readUint64(r)
+		t.HeapAlloc = readUint64(r)
+		t.HeapSys = readUint64(r)
+		t.HeapIdle = readUint64(r)
+		t.HeapInuse = readUint64(r)
+		t.HeapReleased = readUint64(r)
+		t.HeapObjects = readUint64(r)
+		t.StackInuse = readUint64(r)
+		t.StackSys = readUint64(r)
+		t.MSpanInuse = readUint64(r)
+		t.MSpanSys = readUint64(r)
+		t.MCacheInuse = readUint64(r)
+		t.MCacheSys = readUint64(r)
+		t.BuckHashSys = readUint64(r)
+		t.GCSys = readUint64(r)
+		t.OtherSys = readUint64(r)
+		t.NextGC = readUint64(r)
+		t.LastGC = readUint64(r)
+		t.PauseTotalNs = readUint64(r)
+		for i := 0; i < 256; i++ {
+			t.PauseNs[i] = readUint64(r)
+		}
+		t.NumGC = uint32(readUint64(r))
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.MemStats(t)
+	case tagDefer:
+		t := &Defer{}
+		t.Addr = readUint64(r)
+		t.gp = readUint64(r)
+		t.Argp = readUint64(r)
+		t.Pc = readUint64(r)
+		t.FnAddr = readUint64(r)
+		t.Code = readUint64(r)
+		t.link = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.Defer(t)
+	case tagPanic:
+		t := &Panic{}
+		t.Addr = readUint64(r)
+		t.gp = readUint64(r)
+		t.Typ = readUint64(r)
+		t.DataAddr = readUint64(r)
+		t.defr = readUint64(r)
+		t.link = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.Panic(t)
+	case tagMemProf:
+		t := &MemProfEntry{}
+		key := readUint64(r)
+		t.Addr = key
+		t.Size = readUint64(r)
+		nstk := readUint64(r)
+		for i := uint64(0); i < nstk && r.err == nil; i++ {
+			fn := readString(r)
+			file := readString(r)
+			line := readUint64(r)
+			// TODO: intern fn, file.  They will repeat a lot.
+			t.Stack = append(t.Stack, MemProfFrame{fn, file, line})
+		}
+		t.Allocs = readUint64(r)
+		t.Frees = readUint64(r)
+		if r.err != nil {
+			return false, nil
+		}
+		s.memprof[key] = t
+		return false, v.MemProf(t)
+	case tagAllocSample:
+		t := &AllocSample{}
+		t.Addr = readUint64(r)
+		t.Prof = s.memprof[readUint64(r)]
+		if r.err != nil {
+			return false, nil
+		}
+		return false, v.AllocSample(t)
+	default:
+		return false, errors.New("unknown record kind")
+	}
+}
@@ -0,0 +1,86 @@
+package read
+
+import (
+	"io"
+)
+
+// defaultStringLimit is the number of bytes StringValue returns
+// unless StringLimit says otherwise.
+const defaultStringLimit = 64
+
+// StringLimit sets the number of bytes of a string StringValue
+// returns.  Longer strings are truncated.  If n is 0 or negative,
+// strings are never truncated.  The default is 64.
+func StringLimit(n int) Option {
+	return func(c *config) {
+		c.stringLimit = n
+	}
+}
+
+// StringValue returns the contents of the string or byte slice
+// field f of data, which holds an object, a stack frame or a data
+// segment.  Contents longer than the limit set by StringLimit are
+// truncated, so the result may be shorter than the string; its length
+// is in data.  StringValue reports false if f is not a string or byte
+// slice, or its contents are not in the heap, the data segments or
+// the read-only data of the executable.
+func (d *Dump) StringValue(data []byte, f Field) (string, bool) {
+	switch {
+	case f.Kind == FieldKindString:
+	case f.Kind == FieldKindSlice && (f.BaseType == "uint8" || f.BaseType == "byte"):
+	default:
+		return "", false
+	}
+	if f.Offset+2*d.PtrSize > uint64(len(data)) {
+		return "", false
+	}
+	p := readPtr(d, data[f.Offset:])
+	n := readPtr(d, data[f.Offset+d.PtrSize:])
+	if n == 0 {
+		return "", true
+	}
+	if d.stringLimit > 0 && n > uint64(d.stringLimit) {
+		n = uint64(d.stringLimit)
+	}
+	b, ok := d.readMem(p, n)
+	return string(b), ok
+}
+
+// readMem returns up to n bytes of the memory at addr.  The result
+// is short if the object or segment containing addr ends first.  It
+// may refer to the data segments or the executable, so it must not
+// be modified.
+func (d *Dump) readMem(addr, n uint64) ([]byte, bool) {
+	if x := d.FindObj(addr); x != ObjNil {
+		off := addr - d.Addr(x)
+		if m := d.Size(x) - off; n > m {
+			n = m
+		}
+		b := make([]byte, n)
+		m, err := d.r.ReadAt(b, d.objects.offset[x]+int64(off))
+		if err != nil && !(m == len(b) && err == io.EOF) {
+			return nil, false
+		}
+		return b, true
+	}
+	for _, s := range []*Data{d.Data, d.Bss} {
+		if s != nil && addr >= s.Addr && addr-s.Addr < uint64(len(s.Data)) {
+			return clipBytes(s.Data[addr-s.Addr:], n), true
+		}
+	}
+	if d.syms != nil {
+		for _, s := range d.syms.rodata {
+			if addr >= s.addr && addr-s.addr < uint64(len(s.data)) {
+				return clipBytes(s.data[addr-s.addr:], n), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func clipBytes(b []byte, n uint64) []byte {
+	if uint64(len(b)) > n {
+		b = b[:n]
+	}
+	return b
+}
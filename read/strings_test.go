@@ -0,0 +1,36 @@
+package read
+
+import "testing"
+
+// literal is the value of main.lit in the dumper, whose bytes are in
+// the read-only data of the executable rather than the heap.
+const literal = "a string literal in the executable"
+
+func TestStringValue(t *testing.T) {
+	dump := testDump(t)
+	for _, tt := range []struct {
+		limit int // -2 for the default
+		want  string
+	}{
+		{-2, literal},
+		{0, literal},
+		{-1, literal},
+		{len(literal), literal},
+		{8, literal[:8]},
+		{1, literal[:1]},
+	} {
+		var opts []Option
+		if tt.limit != -2 {
+			opts = append(opts, StringLimit(tt.limit))
+		}
+		d := openTest(t, dump, opts...)
+		f, data := global(t, d, "main.lit")
+		if p := readPtr(d, data[f.Offset:]); p != testLiteral {
+			t.Fatalf("main.lit points to %x, want %x", p, testLiteral)
+		}
+		s, ok := d.StringValue(data, f)
+		if !ok || s != tt.want {
+			t.Errorf("limit %d: StringValue(main.lit) = %q, %v, want %q, true", tt.limit, s, ok, tt.want)
+		}
+	}
+}
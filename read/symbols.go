@@ -0,0 +1,311 @@
+package read
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A symtab resolves addresses in the text and data of the
+// executable that wrote a dump.
+type symtab struct {
+	syms   []sym        // sorted by address, see symLess
+	lines  *gosym.Table // from the pclntab, or nil
+	rodata []segment    // read-only data, where string literals live
+}
+
+type segment struct {
+	addr uint64
+	data []byte
+}
+
+type sym struct {
+	name string
+	addr uint64
+	size uint64
+}
+
+// loadSymbols reads the symbol table and the Go line table
+// of an executable.
+func loadSymbols(execname string) (*symtab, error) {
+	var s *symtab
+	var err error
+	if f, e := elf.Open(execname); e == nil {
+		defer f.Close()
+		s, err = elfSymbols(f)
+	} else if f, e := macho.Open(execname); e == nil {
+		defer f.Close()
+		s, err = machoSymbols(f)
+	} else if f, e := pe.Open(execname); e == nil {
+		defer f.Close()
+		s, err = peSymbols(f)
+	} else {
+		return nil, fmt.Errorf("can't read symbols from executable %s: unknown format", execname)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read symbols from executable %s: %v", execname, err)
+	}
+	sort.Slice(s.syms, func(i, j int) bool { return symLess(s.syms[i], s.syms[j]) })
+	return s, nil
+}
+
+// sectionMarkers are the symbols the linker defines at the bounds of
+// sections, mapped to whether they mark the end of one.  They contain
+// nothing, and the start markers share their address with the first
+// symbol in the section.
+var sectionMarkers = map[string]bool{
+	"runtime.text":       false,
+	"runtime.etext":      true,
+	"runtime.rodata":     false,
+	"runtime.erodata":    true,
+	"runtime.types":      false,
+	"runtime.etypes":     true,
+	"runtime.pclntab":    false,
+	"runtime.epclntab":   true,
+	"runtime.noptrdata":  false,
+	"runtime.enoptrdata": true,
+	"runtime.data":       false,
+	"runtime.edata":      true,
+	"runtime.bss":        false,
+	"runtime.ebss":       true,
+	"runtime.noptrbss":   false,
+	"runtime.enoptrbss":  true,
+	"runtime.covctrs":    false,
+	"runtime.ecovctrs":   true,
+	"runtime.egcdata":    true,
+	"runtime.egcbss":     true,
+	"runtime.end":        true,
+}
+
+// symLess orders symbols by address.  Among symbols at the same
+// address, lookup finds the last, so section markers come first, then
+// symbols of unknown size, then sized symbols.
+func symLess(x, y sym) bool {
+	if x.addr != y.addr {
+		return x.addr < y.addr
+	}
+	return symRank(x) < symRank(y)
+}
+
+func symRank(x sym) int {
+	if _, ok := sectionMarkers[x.name]; ok {
+		return 0
+	}
+	if x.size == 0 {
+		return 1
+	}
+	return 2
+}
+
+func elfSymbols(f *elf.File) (*symtab, error) {
+	s := &symtab{}
+	syms, _ := f.Symbols() // stripped binaries still have a line table
+	for _, x := range syms {
+		t := elf.ST_TYPE(x.Info)
+		if x.Section == elf.SHN_UNDEF || x.Value == 0 || (t != elf.STT_FUNC && t != elf.STT_OBJECT && t != elf.STT_NOTYPE) {
+			continue
+		}
+		s.syms = append(s.syms, sym{x.Name, x.Value, x.Size})
+	}
+	if ro := f.Section(".rodata"); ro != nil && ro.Type == elf.SHT_PROGBITS {
+		if data, err := ro.Data(); err == nil {
+			s.rodata = append(s.rodata, segment{ro.Addr, data})
+		}
+	}
+	text := f.Section(".text")
+	pcln := f.Section(".gopclntab")
+	if text == nil || pcln == nil {
+		return s, s.check()
+	}
+	data, err := pcln.Data()
+	if err != nil {
+		return nil, err
+	}
+	return s, s.loadLines(data, text.Addr)
+}
+
+func machoSymbols(f *macho.File) (*symtab, error) {
+	s := &symtab{}
+	if f.Symtab != nil {
+		for _, x := range f.Symtab.Syms {
+			if x.Sect == 0 || x.Value == 0 {
+				continue
+			}
+			s.syms = append(s.syms, sym{x.Name, x.Value, 0})
+		}
+	}
+	if ro := f.Section("__rodata"); ro != nil {
+		if data, err := ro.Data(); err == nil {
+			s.rodata = append(s.rodata, segment{ro.Addr, data})
+		}
+	}
+	text := f.Section("__text")
+	pcln := f.Section("__gopclntab")
+	if text == nil || pcln == nil {
+		return s, s.check()
+	}
+	data, err := pcln.Data()
+	if err != nil {
+		return nil, err
+	}
+	return s, s.loadLines(data, text.Addr)
+}
+
+func peSymbols(f *pe.File) (*symtab, error) {
+	var base uint64
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		base = uint64(h.ImageBase)
+	case *pe.OptionalHeader64:
+		base = h.ImageBase
+	}
+	s := &symtab{}
+	var pcln, epcln *pe.Symbol
+	for _, x := range f.Symbols {
+		if x.SectionNumber <= 0 || int(x.SectionNumber) > len(f.Sections) {
+			continue
+		}
+		sect := f.Sections[x.SectionNumber-1]
+		s.syms = append(s.syms, sym{x.Name, base + uint64(sect.VirtualAddress) + uint64(x.Value), 0})
+		switch x.Name {
+		case "runtime.pclntab":
+			pcln = x
+		case "runtime.epclntab":
+			epcln = x
+		}
+	}
+	if ro := f.Section(".rdata"); ro != nil {
+		if data, err := ro.Data(); err == nil {
+			s.rodata = append(s.rodata, segment{base + uint64(ro.VirtualAddress), data})
+		}
+	}
+	text := f.Section(".text")
+	if text == nil || pcln == nil || epcln == nil || pcln.SectionNumber != epcln.SectionNumber {
+		return s, s.check()
+	}
+	data, err := f.Sections[pcln.SectionNumber-1].Data()
+	if err != nil {
+		return nil, err
+	}
+	if epcln.Value < pcln.Value || int(epcln.Value) > len(data) {
+		return nil, errors.New("bad pclntab symbols")
+	}
+	return s, s.loadLines(data[pcln.Value:epcln.Value], base+uint64(text.VirtualAddress))
+}
+
+func (s *symtab) loadLines(pclntab []byte, text uint64) error {
+	// Since go1.18 the line table is relative to runtime.text,
+	// which need not be the start of the text section.
+	for _, x := range s.syms {
+		if x.name == "runtime.text" {
+			text = x.addr
+			break
+		}
+	}
+	t, err := gosym.NewTable(nil, gosym.NewLineTable(pclntab, text))
+	if err != nil {
+		return err
+	}
+	s.lines = t
+	return nil
+}
+
+func (s *symtab) check() error {
+	if len(s.syms) == 0 {
+		return errors.New("no symbols")
+	}
+	return nil
+}
+
+// lookup returns the symbol containing addr.
+func (s *symtab) lookup(addr uint64) (name string, off uint64, ok bool) {
+	if s.lines != nil {
+		if f := s.lines.PCToFunc(addr); f != nil {
+			return f.Name, addr - f.Entry, true
+		}
+	}
+	i := sort.Search(len(s.syms), func(i int) bool { return s.syms[i].addr > addr }) - 1
+	if i < 0 {
+		return "", 0, false
+	}
+	x := s.syms[i]
+	end := x.addr + x.size
+	if x.size == 0 {
+		// Symbols of unknown size, such as the go:string.* container
+		// of string literals, extend to the next symbol.  Section end
+		// markers, like runtime.etext, are only their own address.
+		end = x.addr + 1
+		if !sectionMarkers[x.name] && i+1 < len(s.syms) {
+			end = s.syms[i+1].addr
+		}
+	}
+	if addr >= end {
+		return "", 0, false
+	}
+	return x.name, addr - x.addr, true
+}
+
+// Symbol returns the name of the symbol in the executable that
+// contains addr, and the offset of addr from its start.  It reports
+// false if the dump was read without an executable or no symbol
+// contains addr.  Symbols include functions as well as global
+// variables and read-only data.
+func (d *Dump) Symbol(addr uint64) (name string, off uint64, ok bool) {
+	if d.syms == nil {
+		return "", 0, false
+	}
+	return d.syms.lookup(addr)
+}
+
+// SymbolString formats addr as symbol+offset, or returns ""
+// if Symbol can't find it.
+func (d *Dump) SymbolString(addr uint64) string {
+	name, off, ok := d.Symbol(addr)
+	switch {
+	case !ok:
+		return ""
+	case off == 0:
+		return name
+	default:
+		return fmt.Sprintf("%s+%#x", name, off)
+	}
+}
+
+// PCLine returns the function, file and line of a program counter.
+// It reports false if the dump was read without an executable, or
+// pc is not in Go code.
+func (d *Dump) PCLine(pc uint64) (fn, file string, line int, ok bool) {
+	if d.syms == nil || d.syms.lines == nil {
+		return "", "", 0, false
+	}
+	file, line, f := d.syms.lines.PCToLine(pc)
+	if f == nil {
+		return "", "", 0, false
+	}
+	return f.Name, file, line, true
+}
+
+// symbolize sets the source positions of the frames and the
+// creation sites of the goroutines of d.
+func symbolize(d *Dump) {
+	for _, f := range d.Frames {
+		pc := f.PC
+		if f.Depth > 0 && pc > f.Entry {
+			// Callers are suspended at the return address,
+			// which may be on the line after the call.
+			pc--
+		}
+		_, f.File, f.Line, _ = d.PCLine(pc)
+	}
+	for _, g := range d.Goroutines {
+		pc := g.Gopc
+		if pc > 0 {
+			pc-- // return address of the go statement's call
+		}
+		g.CreatedBy, g.CreatedFile, g.CreatedLine, _ = d.PCLine(pc)
+	}
+}
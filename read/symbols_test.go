@@ -0,0 +1,45 @@
+package read
+
+import "testing"
+
+// TestSymbolStringLiteral checks that a pointer to the bytes of a
+// string literal resolves to the container symbol of string data,
+// which has no size in the symbol table.
+func TestSymbolStringLiteral(t *testing.T) {
+	d := openTest(t, testDump(t))
+	if d.syms == nil {
+		t.Fatal("no symbols loaded")
+	}
+	name, off, ok := d.Symbol(testLiteral)
+	if !ok {
+		t.Fatalf("no symbol for string literal at %x", testLiteral)
+	}
+	if name != "go:string.*" && name != "go.string.*" {
+		t.Errorf("string literal at %x is in %s+%#x, want go:string.*", testLiteral, name, off)
+	}
+}
+
+// TestSymbolEndMarker checks that section end markers contain only
+// their own address.
+func TestSymbolEndMarker(t *testing.T) {
+	testDump(t)
+	s, err := loadSymbols(testExec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, x := range s.syms {
+		if x.name != "runtime.etext" {
+			continue
+		}
+		if name, _, ok := s.lookup(x.addr); !ok || name != x.name {
+			t.Errorf("lookup(%x) = %s, %v, want %s", x.addr, name, ok, x.name)
+		}
+		if i+1 < len(s.syms) && s.syms[i+1].addr > x.addr+1 {
+			if name, off, ok := s.lookup(x.addr + 1); ok {
+				t.Errorf("lookup(%x) = %s+%#x, want no symbol", x.addr+1, name, off)
+			}
+		}
+		return
+	}
+	t.Skip("no runtime.etext symbol")
+}
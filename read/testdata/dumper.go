@@ -0,0 +1,71 @@
+// Dumper writes a heap dump of itself to the file named by its
+// argument, for the tests of package read.  It prints the address of
+// the bytes of a string literal, which are in the executable.
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"unsafe"
+)
+
+const literal = "a string literal in the executable"
+
+type link struct {
+	next *link
+	name string
+}
+
+// The heap holds some maps, channels, strings and pointers between
+// objects.
+var (
+	m       map[string]*[16]int
+	c       chan string
+	blocked chan int // received from by 3 blocked goroutines
+	s       []string
+	links   *link
+	lit     = literal
+	dups    [4]string // equal strings, each in its own object
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: dumper file")
+		os.Exit(2)
+	}
+	m = map[string]*[16]int{}
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		m[k] = new([16]int)
+	}
+	c = make(chan string, 4)
+	c <- "first"
+	c <- "second"
+	for i := 0; i < 100; i++ {
+		links = &link{links, string(rune('a' + i%26))}
+		s = append(s, links.name)
+	}
+	for i := range dups {
+		dups[i] = strings.Repeat("dup", 8)
+	}
+	blocked = make(chan int)
+	for i := 0; i < 3; i++ {
+		go func() { <-blocked }()
+	}
+	runtime.Gosched()
+
+	f, err := os.Create(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	runtime.GC()
+	debug.WriteHeapDump(f.Fd())
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%x\n", uintptr(unsafe.Pointer(unsafe.StringData(lit))))
+}